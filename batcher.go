@@ -0,0 +1,195 @@
+package fsevents
+
+import (
+	"time"
+)
+
+// Clock tells the time and makes timers. It's used by Batcher and FakeStream
+// so that tests can use a virtual clock instead of waiting.
+type Clock interface {
+	Now() time.Time
+	NewTimer(d time.Duration) Timer
+}
+
+// Timer is a timer made by a Clock, like time.Timer.
+type Timer interface {
+	// C returns the channel on which the time is sent when the timer
+	// fires.
+	C() <-chan time.Time
+
+	// Stop prevents the timer from firing, like time.Timer.Stop.
+	Stop() bool
+}
+
+// realClock is the Clock of the time package.
+type realClock struct{}
+
+func (realClock) Now() time.Time                 { return time.Now() }
+func (realClock) NewTimer(d time.Duration) Timer { return realTimer{time.NewTimer(d)} }
+
+type realTimer struct{ t *time.Timer }
+
+func (t realTimer) C() <-chan time.Time { return t.t.C }
+func (t realTimer) Stop() bool          { return t.t.Stop() }
+
+// Batcher groups events into batches the way FSEvents does, according to the
+// Latency and NoDefer described on EventStream:
+//
+//   - By default, an event that occurs after a period of no events starts the
+//     latency timer, and all events until it expires are delivered as one
+//     group, including the first one.
+//
+//   - With NoDefer, an event is delivered right away if more than Latency has
+//     passed since the last delivery. Otherwise, it's delivered with all the
+//     following ones once Latency has passed since the last delivery.
+//
+//   - With a zero Latency, events are delivered right away.
+//
+// Batcher doesn't deliver anything itself: Add returns what should be
+// delivered right away, and the caller calls Due once the time returned by
+// Next has passed:
+//
+//	if batch := b.Add(events); batch != nil {
+//		deliver(batch)
+//	}
+//	if d, ok := b.Next(); ok {
+//		// Call b.Due() after d.
+//	}
+//
+// The zero value is ready to use, with a zero Latency and the real time.
+type Batcher struct {
+	// Latency holds how long events are held back to group them.
+	Latency time.Duration
+
+	// NoDefer delivers events on the leading edge, rather than the
+	// trailing edge.
+	NoDefer bool
+
+	// Clock tells the time. It defaults to the real time.
+	Clock Clock
+
+	pending []Event
+	due     time.Time // when pending is due
+	last    time.Time // last delivery
+}
+
+// NewBatcher returns a Batcher for a stream with the given Latency and Flags.
+func NewBatcher(latency time.Duration, flags CreateFlags) *Batcher {
+	return &Batcher{Latency: latency, NoDefer: flags&NoDefer != 0}
+}
+
+func (b *Batcher) clock() Clock {
+	if b.Clock == nil {
+		return realClock{}
+	}
+	return b.Clock
+}
+
+func (b *Batcher) now() time.Time {
+	return b.clock().Now()
+}
+
+// Add adds events that just occurred. It returns the events to deliver right
+// away, if any.
+func (b *Batcher) Add(events []Event) []Event {
+	if len(events) == 0 {
+		return nil
+	}
+
+	now := b.now()
+	if len(b.pending) == 0 {
+		switch {
+		case b.Latency <= 0:
+			b.last = now
+			return events
+		case b.NoDefer:
+			if b.last.IsZero() || now.Sub(b.last) >= b.Latency {
+				b.last = now
+				return events
+			}
+			b.due = b.last.Add(b.Latency)
+		default:
+			b.due = now.Add(b.Latency)
+		}
+	}
+	b.pending = append(b.pending, events...)
+
+	// The caller may not have called Due in time.
+	return b.Due()
+}
+
+// Next returns how long until the pending events are due, or false if there
+// are none.
+func (b *Batcher) Next() (time.Duration, bool) {
+	if len(b.pending) == 0 {
+		return 0, false
+	}
+	d := b.due.Sub(b.now())
+	if d < 0 {
+		d = 0
+	}
+	return d, true
+}
+
+// Due returns the pending events if they're due, or nil.
+func (b *Batcher) Due() []Event {
+	if len(b.pending) == 0 {
+		return nil
+	}
+	now := b.now()
+	if now.Before(b.due) {
+		return nil
+	}
+	return b.take(now)
+}
+
+// Flush returns all the pending events, whether they're due or not.
+func (b *Batcher) Flush() []Event {
+	if len(b.pending) == 0 {
+		return nil
+	}
+	return b.take(b.now())
+}
+
+// Len returns the number of pending events.
+func (b *Batcher) Len() int {
+	return len(b.pending)
+}
+
+func (b *Batcher) take(now time.Time) []Event {
+	events := b.pending
+	b.pending = nil
+	b.due = time.Time{}
+	b.last = now
+	return events
+}
+
+// batchTimer keeps a timer for when the pending events of a Batcher are due,
+// for loops that select on it.
+type batchTimer struct {
+	b *Batcher
+	t Timer
+}
+
+// C returns the channel of the timer, or nil if nothing is pending.
+func (bt *batchTimer) C() <-chan time.Time {
+	if bt.t == nil {
+		return nil
+	}
+	return bt.t.C()
+}
+
+// reset sets the timer for the pending events, after they changed.
+func (bt *batchTimer) reset() {
+	bt.stop()
+	if d, ok := bt.b.Next(); ok {
+		bt.t = bt.b.clock().NewTimer(d)
+	}
+}
+
+func (bt *batchTimer) stop() {
+	if bt.t != nil {
+		bt.t.Stop()
+		bt.t = nil
+	}
+}
@@ -0,0 +1,210 @@
+package fsevents
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// virtualClock is a Clock whose time only changes with Advance.
+type virtualClock struct {
+	mu     sync.Mutex
+	now    time.Time
+	timers []*virtualTimer
+}
+
+type virtualTimer struct {
+	c     chan time.Time
+	when  time.Time
+	clock *virtualClock
+}
+
+func newVirtualClock() *virtualClock {
+	return &virtualClock{now: time.Date(2024, 1, 2, 10, 0, 0, 0, time.UTC)}
+}
+
+func (c *virtualClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *virtualClock) NewTimer(d time.Duration) Timer {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	t := &virtualTimer{c: make(chan time.Time, 1), when: c.now.Add(d), clock: c}
+	if d <= 0 {
+		t.c <- c.now
+		return t
+	}
+	c.timers = append(c.timers, t)
+	return t
+}
+
+// Advance moves the time forward by d, firing the timers that expire.
+func (c *virtualClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.now = c.now.Add(d)
+	timers := c.timers[:0]
+	for _, t := range c.timers {
+		if t.when.After(c.now) {
+			timers = append(timers, t)
+			continue
+		}
+		t.c <- c.now
+	}
+	c.timers = timers
+}
+
+func (t *virtualTimer) C() <-chan time.Time { return t.c }
+
+func (t *virtualTimer) Stop() bool {
+	t.clock.mu.Lock()
+	defer t.clock.mu.Unlock()
+
+	for i, tt := range t.clock.timers {
+		if tt == t {
+			t.clock.timers = append(t.clock.timers[:i], t.clock.timers[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// simulate adds events at the given offsets, calling Due whenever the
+// pending events are due, and returns the batches as "offset:paths".
+func simulate(b *Batcher, clock *virtualClock, adds map[time.Duration]string, end time.Duration) string {
+	var at []time.Duration
+	for d := range adds {
+		at = append(at, d)
+	}
+	sort.Slice(at, func(i, j int) bool { return at[i] < at[j] })
+
+	var (
+		out     []string
+		elapsed time.Duration
+	)
+	deliver := func(events []Event) {
+		if events == nil {
+			return
+		}
+		paths := make([]string, 0, len(events))
+		for _, e := range events {
+			paths = append(paths, e.Path)
+		}
+		out = append(out, fmt.Sprintf("%d:%s", elapsed/time.Millisecond, strings.Join(paths, ",")))
+	}
+	advance := func(to time.Duration) {
+		// Stop at every deadline on the way.
+		for {
+			d, ok := b.Next()
+			if !ok || elapsed+d > to {
+				break
+			}
+			clock.Advance(d)
+			elapsed += d
+			deliver(b.Due())
+		}
+		clock.Advance(to - elapsed)
+		elapsed = to
+	}
+
+	for _, d := range at {
+		advance(d)
+		var events []Event
+		for _, p := range strings.Split(adds[d], ",") {
+			events = append(events, Event{Path: p})
+		}
+		deliver(b.Add(events))
+	}
+	advance(end)
+	return strings.Join(out, " ")
+}
+
+func TestBatcher(t *testing.T) {
+	const ms = time.Millisecond
+
+	tests := []struct {
+		name    string
+		latency time.Duration
+		flags   CreateFlags
+		adds    map[time.Duration]string
+		want    string
+	}{
+		{"no latency", 0, 0,
+			map[time.Duration]string{0: "a", 10 * ms: "b,c", 20 * ms: "d"},
+			"0:a 10:b,c 20:d"},
+		{"no latency NoDefer", 0, NoDefer,
+			map[time.Duration]string{0: "a", 10 * ms: "b"},
+			"0:a 10:b"},
+
+		// Everything within the latency of the first event is one group,
+		// including the first one.
+		{"trailing", 100 * ms, 0,
+			map[time.Duration]string{0: "a", 50 * ms: "b", 99 * ms: "c", 100 * ms: "d", 150 * ms: "e", 400 * ms: "f"},
+			"100:a,b,c 200:d,e 500:f"},
+		{"trailing idle", 100 * ms, 0,
+			map[time.Duration]string{30 * ms: "a", 500 * ms: "b"},
+			"130:a 600:b"},
+
+		// The first event is delivered right away, and further events
+		// once the latency has passed since the delivery.
+		{"leading", 100 * ms, NoDefer,
+			map[time.Duration]string{0: "a", 50 * ms: "b", 80 * ms: "c", 150 * ms: "d", 210 * ms: "e"},
+			"0:a 100:b,c 200:d 300:e"},
+		{"leading idle", 100 * ms, NoDefer,
+			map[time.Duration]string{0: "a", 100 * ms: "b", 350 * ms: "c", 400 * ms: "d"},
+			"0:a 100:b 350:c 450:d"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clock := newVirtualClock()
+			b := NewBatcher(tt.latency, tt.flags)
+			b.Clock = clock
+
+			have := simulate(b, clock, tt.adds, time.Second)
+			if have != tt.want {
+				t.Errorf("\nhave: %s\nwant: %s", have, tt.want)
+			}
+			if b.Len() != 0 {
+				t.Errorf("%d events still pending", b.Len())
+			}
+		})
+	}
+}
+
+func TestBatcherFlush(t *testing.T) {
+	clock := newVirtualClock()
+	b := NewBatcher(time.Second, 0)
+	b.Clock = clock
+
+	if have := b.Add([]Event{{Path: "a"}}); have != nil {
+		t.Fatalf("delivered right away: %v", have)
+	}
+	clock.Advance(time.Millisecond)
+	if d, ok := b.Next(); !ok || d != time.Second-time.Millisecond {
+		t.Errorf("Next() = %s, %t", d, ok)
+	}
+	if have := b.Due(); have != nil {
+		t.Errorf("due early: %v", have)
+	}
+	if have := b.Flush(); len(have) != 1 {
+		t.Errorf("Flush() = %v", have)
+	}
+	if _, ok := b.Next(); ok {
+		t.Error("still pending after Flush")
+	}
+
+	// A Due that's late is picked up by the next Add.
+	b.Add([]Event{{Path: "b"}})
+	clock.Advance(2 * time.Second)
+	if have := b.Add([]Event{{Path: "c"}}); len(have) != 2 {
+		t.Errorf("Add() after the deadline = %v", have)
+	}
+}
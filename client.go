@@ -0,0 +1,300 @@
+package fsevents
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+// The protocol between a Server and a Client is JSON lines. The client sends a
+// request, the server answers with a response, and then sends a message for
+// every batch of events. The events have the same encoding as in recordings.
+type (
+	remoteRequest struct {
+		Stream  string `json:"stream"`
+		Resume  bool   `json:"resume"`
+		EventID uint64 `json:"event_id"`
+	}
+	remoteResponse struct {
+		Error string `json:"error,omitempty"`
+		Ended bool   `json:"ended,omitempty"` // the stream was stopped
+	}
+	remoteBatch struct {
+		Events []recordEvent `json:"events"`
+	}
+)
+
+// defaultRetryInterval is the Client.RetryInterval used if it's not set.
+const defaultRetryInterval = time.Second
+
+// Client receives the events of an EventStream served by a Server. It has the
+// same Start/Stop/Events/EventID/Resume surface as EventStream:
+//
+//	c := &fsevents.Client{Network: "unix", Address: "/run/fsevents.sock", Stream: "src"}
+//	c.Start()
+//	for msg := range c.Events {
+//		...
+//	}
+//
+// If the connection is lost, the client reconnects and resumes from EventID:
+// the batches it missed are sent first, followed by an event with the
+// HistoryDone flag. If the stream was stopped on the server, the client stops
+// instead and closes Events, and Err returns ErrStreamEnded.
+type Client struct {
+	// Network and Address of the server, as passed to net.Dial.
+	Network string
+	Address string
+
+	// Stream holds the name of the stream on the server.
+	Stream string
+
+	// Events holds the channel on which events will be sent.
+	// It's initialized by Client.Start if nil, and replaced by a new
+	// channel with the same capacity if it was closed.
+	Events chan []Event
+
+	// Resume requests the batches since EventID that are still kept by the
+	// server, followed by a HistoryDone event, before the live events.
+	Resume bool
+
+	// EventID holds the most recent event ID.
+	//
+	// NOTE: this is updated asynchronously by the client, the same way
+	// it is for EventStream.
+	EventID uint64
+
+	// RetryInterval holds how long to wait before reconnecting after the
+	// connection to the server is lost. It defaults to one second.
+	RetryInterval time.Duration
+
+	mu     sync.Mutex
+	conn   net.Conn
+	done   chan struct{} // closed by Stop
+	exited chan struct{} // closed when the client exits
+	closed bool          // Events was closed
+	err    error         // why the client stopped, returned by Err
+}
+
+// Start connects to the server and starts receiving events. This creates
+// Events if it's not already a valid channel.
+func (c *Client) Start() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.done != nil {
+		return ErrAlreadyStarted
+	}
+	conn, r, err := c.dial(c.Resume, c.EventID)
+	if err != nil {
+		return err
+	}
+	if c.Events == nil {
+		c.Events = make(chan []Event)
+	} else if c.closed {
+		c.Events = make(chan []Event, cap(c.Events))
+		c.closed = false
+	}
+	c.err = nil
+	c.conn = conn
+	c.done = make(chan struct{})
+	c.exited = make(chan struct{})
+
+	go c.run(r, c.done, c.exited)
+	return nil
+}
+
+// Stop disconnects from the server, and closes c.Events. It does nothing if
+// the client isn't started.
+func (c *Client) Stop() {
+	if c.stop() {
+		c.closeEvents()
+	}
+}
+
+// Err returns why the client stopped on its own, such as ErrStreamEnded when
+// the stream was stopped on the server, or nil. It's meant to be called once
+// Events is closed.
+func (c *Client) Err() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.err
+}
+
+// stop disconnects from the server and waits for the client to exit. It
+// returns false if the client wasn't started.
+func (c *Client) stop() bool {
+	c.mu.Lock()
+	if c.done == nil {
+		c.mu.Unlock()
+		return false
+	}
+	done, exited := c.done, c.exited
+	c.done, c.exited = nil, nil
+	close(done)
+	c.conn.Close()
+	c.mu.Unlock()
+
+	<-exited
+	return true
+}
+
+// Restart reconnects to the server, resuming from EventID. Events stays open,
+// unless the client can't connect again.
+func (c *Client) Restart() error {
+	stopped := c.stop()
+	c.Resume = true
+	err := c.Start()
+	if err != nil && stopped {
+		c.closeEvents()
+	}
+	return err
+}
+
+// closeEvents closes Events, which is replaced by the next Start.
+func (c *Client) closeEvents() {
+	c.mu.Lock()
+	close(c.Events)
+	c.closed = true
+	c.mu.Unlock()
+}
+
+// dial connects to the server and sends the request.
+func (c *Client) dial(resume bool, eventID uint64) (net.Conn, *bufio.Reader, error) {
+	conn, err := net.Dial(c.Network, c.Address)
+	if err != nil {
+		return nil, nil, fmt.Errorf("fsevents: %w", err)
+	}
+	err = json.NewEncoder(conn).Encode(remoteRequest{Stream: c.Stream, Resume: resume, EventID: eventID})
+	if err != nil {
+		conn.Close()
+		return nil, nil, fmt.Errorf("fsevents: %w", err)
+	}
+
+	r := bufio.NewReader(conn)
+	line, err := r.ReadBytes('\n')
+	if err != nil {
+		conn.Close()
+		return nil, nil, fmt.Errorf("fsevents: reading response from %s: %w", c.Address, err)
+	}
+	var resp remoteResponse
+	if err := json.Unmarshal(line, &resp); err != nil {
+		conn.Close()
+		return nil, nil, fmt.Errorf("fsevents: reading response from %s: %w", c.Address, err)
+	}
+	if resp.Ended {
+		conn.Close()
+		return nil, nil, fmt.Errorf("%w: %s: %s", ErrStreamEnded, c.Address, resp.Error)
+	}
+	if resp.Error != "" {
+		conn.Close()
+		return nil, nil, fmt.Errorf("fsevents: %s: %s", c.Address, resp.Error)
+	}
+	return conn, r, nil
+}
+
+// run receives batches until the client is stopped, and reconnects if the
+// connection is lost.
+func (c *Client) run(r *bufio.Reader, done, exited chan struct{}) {
+	defer close(exited)
+
+	interval := c.RetryInterval
+	if interval <= 0 {
+		interval = defaultRetryInterval
+	}
+	for {
+		if !c.receive(r, done) {
+			return
+		}
+
+		// The connection was lost; reconnect.
+		for {
+			select {
+			case <-done:
+				return
+			case <-time.After(interval):
+			}
+			conn, nr, err := c.dial(true, c.EventID)
+			if errors.Is(err, ErrStreamEnded) {
+				c.end(done, err)
+				return
+			}
+			if err != nil {
+				continue
+			}
+
+			c.mu.Lock()
+			select {
+			case <-done:
+				c.mu.Unlock()
+				conn.Close()
+				return
+			default:
+			}
+			c.conn.Close()
+			c.conn = conn
+			c.mu.Unlock()
+
+			r = nr
+			break
+		}
+	}
+}
+
+// end stops the client on its own because of err, unless Stop was called
+// meanwhile, and closes Events.
+func (c *Client) end(done chan struct{}, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.done != done {
+		return
+	}
+	c.done, c.exited = nil, nil
+	c.conn.Close()
+	close(c.Events)
+	c.closed = true
+	c.err = err
+}
+
+// receive sends the batches read from r on Events. It returns false if done
+// was closed, and true if the connection was lost.
+func (c *Client) receive(r *bufio.Reader, done chan struct{}) bool {
+	for {
+		line, err := r.ReadBytes('\n')
+		if err != nil {
+			break
+		}
+		var b remoteBatch
+		if err := json.Unmarshal(line, &b); err != nil {
+			break
+		}
+
+		var (
+			events = eventsFromRecord(b.Events)
+			prev   = c.EventID
+		)
+		for _, e := range events {
+			if e.ID != 0 {
+				c.EventID = e.ID
+			}
+		}
+		select {
+		case c.Events <- events:
+		case <-done:
+			// Not delivered; don't skip it when resuming.
+			c.EventID = prev
+			return false
+		}
+	}
+
+	select {
+	case <-done:
+		return false
+	default:
+		return true
+	}
+}
@@ -0,0 +1,314 @@
+package fsevents
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// flagName is the name of a flag, as used by String and the Parse functions.
+type flagName struct {
+	value uint32
+	name  string
+}
+
+// eventFlagNames holds the names of the EventFlags, in the order of their
+// values.
+var eventFlagNames = []flagName{
+	{uint32(MustScanSubDirs), "MustScanSubDirs"},
+	{uint32(UserDropped), "UserDropped"},
+	{uint32(KernelDropped), "KernelDropped"},
+	{uint32(EventIDsWrapped), "EventIDsWrapped"},
+	{uint32(HistoryDone), "HistoryDone"},
+	{uint32(RootChanged), "RootChanged"},
+	{uint32(Mount), "Mount"},
+	{uint32(Unmount), "Unmount"},
+	{uint32(ItemCreated), "ItemCreated"},
+	{uint32(ItemRemoved), "ItemRemoved"},
+	{uint32(ItemInodeMetaMod), "ItemInodeMetaMod"},
+	{uint32(ItemRenamed), "ItemRenamed"},
+	{uint32(ItemModified), "ItemModified"},
+	{uint32(ItemFinderInfoMod), "ItemFinderInfoMod"},
+	{uint32(ItemChangeOwner), "ItemChangeOwner"},
+	{uint32(ItemXattrMod), "ItemXattrMod"},
+	{uint32(ItemIsFile), "ItemIsFile"},
+	{uint32(ItemIsDir), "ItemIsDir"},
+	{uint32(ItemIsSymlink), "ItemIsSymlink"},
+	{uint32(OwnEvent), "OwnEvent"},
+	{uint32(ItemIsHardlink), "ItemIsHardlink"},
+	{uint32(ItemIsLastHardlink), "ItemIsLastHardlink"},
+	{uint32(ItemCloned), "ItemCloned"},
+}
+
+// createFlagNames holds the names of the CreateFlags, in the order of their
+// values.
+var createFlagNames = []flagName{
+	{uint32(NoDefer), "NoDefer"},
+	{uint32(WatchRoot), "WatchRoot"},
+	{uint32(IgnoreSelf), "IgnoreSelf"},
+	{uint32(FileEvents), "FileEvents"},
+	{uint32(MarkSelf), "MarkSelf"},
+	{uint32(UseExtendedData), "UseExtendedData"},
+	{uint32(FullHistory), "FullHistory"},
+}
+
+// formatFlags returns the names of the flags set in v separated by "|", with
+// the bits that don't have a name in hexadecimal, or "0" if none are set.
+func formatFlags(v uint32, names []flagName) string {
+	if v == 0 {
+		return "0"
+	}
+	var parts []string
+	for _, n := range names {
+		if v&n.value != 0 {
+			parts = append(parts, n.name)
+			v &^= n.value
+		}
+	}
+	if v != 0 {
+		parts = append(parts, fmt.Sprintf("%#x", v))
+	}
+	return strings.Join(parts, "|")
+}
+
+// parseFlags parses flags formatted by formatFlags. The names are matched
+// without regard to case, and numbers can be in any base strconv.ParseUint
+// accepts with a prefix.
+func parseFlags(s string, names []flagName, kind string) (uint32, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return 0, nil
+	}
+	var v uint32
+	for _, part := range strings.Split(s, "|") {
+		part = strings.TrimSpace(part)
+		if n, ok := lookupFlag(part, names); ok {
+			v |= n
+			continue
+		}
+		n, err := strconv.ParseUint(part, 0, 32)
+		if err != nil {
+			return 0, fmt.Errorf("fsevents: invalid %s flag %q", kind, part)
+		}
+		v |= uint32(n)
+	}
+	return v, nil
+}
+
+func lookupFlag(name string, names []flagName) (uint32, bool) {
+	for _, n := range names {
+		if strings.EqualFold(name, n.name) {
+			return n.value, true
+		}
+	}
+	return 0, false
+}
+
+// String returns the names of the flags separated by "|", such as
+// "ItemCreated|ItemIsFile". Bits without a name are added in hexadecimal, and
+// no flags are "0".
+func (f EventFlags) String() string {
+	return formatFlags(uint32(f), eventFlagNames)
+}
+
+// ParseEventFlags parses flags in the format of EventFlags.String. The names
+// are matched without regard to case, and numbers such as 0x100 are accepted
+// as well.
+func ParseEventFlags(s string) (EventFlags, error) {
+	v, err := parseFlags(s, eventFlagNames, "event")
+	return EventFlags(v), err
+}
+
+// MarshalText returns the flags as formatted by String, which is also how
+// they're marshalled to JSON.
+func (f EventFlags) MarshalText() ([]byte, error) {
+	return []byte(f.String()), nil
+}
+
+// UnmarshalText parses flags with ParseEventFlags.
+func (f *EventFlags) UnmarshalText(text []byte) error {
+	v, err := ParseEventFlags(string(text))
+	if err != nil {
+		return err
+	}
+	*f = v
+	return nil
+}
+
+// String returns the names of the flags separated by "|", such as
+// "NoDefer|FileEvents". Bits without a name are added in hexadecimal, and no
+// flags are "0".
+func (f CreateFlags) String() string {
+	return formatFlags(uint32(f), createFlagNames)
+}
+
+// ParseCreateFlags parses flags in the format of CreateFlags.String, like
+// ParseEventFlags.
+func ParseCreateFlags(s string) (CreateFlags, error) {
+	v, err := parseFlags(s, createFlagNames, "create")
+	return CreateFlags(v), err
+}
+
+// MarshalText returns the flags as formatted by String, which is also how
+// they're marshalled to JSON.
+func (f CreateFlags) MarshalText() ([]byte, error) {
+	return []byte(f.String()), nil
+}
+
+// UnmarshalText parses flags with ParseCreateFlags.
+func (f *CreateFlags) UnmarshalText(text []byte) error {
+	v, err := ParseCreateFlags(string(text))
+	if err != nil {
+		return err
+	}
+	*f = v
+	return nil
+}
+
+// String returns the flags, the quoted path, the ID and the inode if it's
+// known, such as:
+//
+//	ItemCreated|ItemIsFile "/tmp/file" id=42 inode=1234
+func (e Event) String() string {
+	s := fmt.Sprintf("%s %q id=%d", e.Flags, e.Path, e.ID)
+	if e.Inode != 0 {
+		s += fmt.Sprintf(" inode=%d", e.Inode)
+	}
+	return s
+}
+
+// batchVersion is the first byte of the binary encoding of a batch.
+const batchVersion = 1
+
+// AppendBatch appends the binary encoding of a batch of events to b and
+// returns the extended buffer. The encoding is compact: numbers are varints,
+// IDs are stored as the difference with the previous event, and paths as the
+// length of the prefix they share with the previous path and the rest. It
+// includes the number of events, so batches can be concatenated and decoded
+// one after the other with DecodeBatch.
+//
+// The encoding starts with a version byte and won't change for the version.
+func AppendBatch(b []byte, events []Event) []byte {
+	var (
+		buf  [binary.MaxVarintLen64]byte
+		prev Event
+	)
+	putUvarint := func(v uint64) {
+		b = append(b, buf[:binary.PutUvarint(buf[:], v)]...)
+	}
+
+	b = append(b, batchVersion)
+	putUvarint(uint64(len(events)))
+	for _, e := range events {
+		b = append(b, buf[:binary.PutVarint(buf[:], int64(e.ID-prev.ID))]...)
+		putUvarint(uint64(e.Flags))
+		putUvarint(e.Inode)
+		shared := sharedPrefix(prev.Path, e.Path)
+		putUvarint(uint64(shared))
+		putUvarint(uint64(len(e.Path) - shared))
+		b = append(b, e.Path[shared:]...)
+		prev = e
+	}
+	return b
+}
+
+// DecodeBatch decodes a batch encoded by AppendBatch from the start of b. It
+// returns the events, and the number of bytes of b the batch took. The error
+// matches ErrInvalidBatch if b doesn't start with a valid batch.
+func DecodeBatch(b []byte) ([]Event, int, error) {
+	d := batchDecoder{b: b}
+	if v := d.byte(); d.err == nil && v != batchVersion {
+		return nil, 0, fmt.Errorf("%w: unknown version %d", ErrInvalidBatch, v)
+	}
+	n := d.uvarint()
+	if d.err != nil {
+		return nil, 0, d.err
+	}
+	// Every event takes at least five bytes, which bounds the number of
+	// events of a valid batch.
+	if n > uint64(len(b)-d.off)/5 {
+		return nil, 0, fmt.Errorf("%w: %d events in %d bytes", ErrInvalidBatch, n, len(b))
+	}
+
+	var (
+		events = make([]Event, 0, n)
+		prev   Event
+	)
+	for i := uint64(0); i < n; i++ {
+		var e Event
+		e.ID = prev.ID + uint64(d.varint())
+		flags := d.uvarint()
+		e.Inode = d.uvarint()
+		shared, rest := d.uvarint(), d.uvarint()
+		if d.err != nil {
+			return nil, 0, d.err
+		}
+		if flags > 1<<32-1 {
+			return nil, 0, fmt.Errorf("%w: flags out of range", ErrInvalidBatch)
+		}
+		if shared > uint64(len(prev.Path)) || rest > uint64(len(b)-d.off) {
+			return nil, 0, fmt.Errorf("%w: path out of range", ErrInvalidBatch)
+		}
+		e.Flags = EventFlags(flags)
+		e.Path = prev.Path[:shared] + string(b[d.off:d.off+int(rest)])
+		d.off += int(rest)
+		events = append(events, e)
+		prev = e
+	}
+	return events, d.off, nil
+}
+
+// sharedPrefix returns the length of the prefix a and b share.
+func sharedPrefix(a, b string) int {
+	n := 0
+	for n < len(a) && n < len(b) && a[n] == b[n] {
+		n++
+	}
+	return n
+}
+
+// batchDecoder reads the numbers of a batch, and keeps the first error.
+type batchDecoder struct {
+	b   []byte
+	off int
+	err error
+}
+
+func (d *batchDecoder) byte() byte {
+	if d.err != nil {
+		return 0
+	}
+	if d.off >= len(d.b) {
+		d.err = fmt.Errorf("%w: truncated", ErrInvalidBatch)
+		return 0
+	}
+	d.off++
+	return d.b[d.off-1]
+}
+
+func (d *batchDecoder) uvarint() uint64 {
+	if d.err != nil {
+		return 0
+	}
+	v, n := binary.Uvarint(d.b[d.off:])
+	if n <= 0 {
+		d.err = fmt.Errorf("%w: truncated or overflowing number", ErrInvalidBatch)
+		return 0
+	}
+	d.off += n
+	return v
+}
+
+func (d *batchDecoder) varint() int64 {
+	if d.err != nil {
+		return 0
+	}
+	v, n := binary.Varint(d.b[d.off:])
+	if n <= 0 {
+		d.err = fmt.Errorf("%w: truncated or overflowing number", ErrInvalidBatch)
+		return 0
+	}
+	d.off += n
+	return v
+}
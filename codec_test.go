@@ -0,0 +1,180 @@
+package fsevents
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestEventFlagsString(t *testing.T) {
+	tests := []struct {
+		flags EventFlags
+		want  string
+	}{
+		{0, "0"},
+		{ItemCreated | ItemIsFile, "ItemCreated|ItemIsFile"},
+		{ItemIsFile | ItemCreated | ItemModified | ItemRemoved, "ItemCreated|ItemRemoved|ItemModified|ItemIsFile"},
+		{MustScanSubDirs | UserDropped, "MustScanSubDirs|UserDropped"},
+		{ItemCloned | 0x3000000, "ItemCloned|0x3000000"},
+	}
+	for _, tt := range tests {
+		if have := tt.flags.String(); have != tt.want {
+			t.Errorf("%#x: have %q, want %q", uint32(tt.flags), have, tt.want)
+		}
+		flags, err := ParseEventFlags(tt.want)
+		if err != nil || flags != tt.flags {
+			t.Errorf("ParseEventFlags(%q) = %#x, %v", tt.want, uint32(flags), err)
+		}
+	}
+
+	// Every flag has a name.
+	for _, n := range eventFlagNames {
+		flags, err := ParseEventFlags(n.name)
+		if err != nil || uint32(flags) != n.value || flags.String() != n.name {
+			t.Errorf("%s: parsed as %#x, %v", n.name, uint32(flags), err)
+		}
+	}
+}
+
+func TestParseEventFlags(t *testing.T) {
+	tests := []struct {
+		in   string
+		want EventFlags
+		err  bool
+	}{
+		{"", 0, false},
+		{"itemcreated|ITEMISFILE", ItemCreated | ItemIsFile, false},
+		{" ItemCreated | ItemIsFile ", ItemCreated | ItemIsFile, false},
+		{"0x100|ItemIsFile", ItemCreated | ItemIsFile, false},
+		{"ItemIsFile|", 0, true},
+		{"ItemUnknown", 0, true},
+		{"0x100000000", 0, true},
+		{"NoDefer", 0, true},
+	}
+	for _, tt := range tests {
+		have, err := ParseEventFlags(tt.in)
+		if (err != nil) != tt.err || have != tt.want {
+			t.Errorf("ParseEventFlags(%q) = %#x, %v", tt.in, uint32(have), err)
+		}
+	}
+}
+
+func TestCreateFlagsText(t *testing.T) {
+	flags := NoDefer | FileEvents | FullHistory
+	text, err := flags.MarshalText()
+	if err != nil || string(text) != "NoDefer|FileEvents|FullHistory" {
+		t.Fatalf("MarshalText returned %q, %v", text, err)
+	}
+	var have CreateFlags
+	if err := have.UnmarshalText(text); err != nil || have != flags {
+		t.Errorf("UnmarshalText returned %#x, %v", uint32(have), err)
+	}
+	if err := have.UnmarshalText([]byte("ItemCreated")); err == nil {
+		t.Error("no error for an event flag")
+	}
+}
+
+func TestEventJSON(t *testing.T) {
+	events := []Event{
+		{Path: "/tmp/file", Flags: ItemCreated | ItemIsFile, ID: 42, Inode: 1234},
+		{Flags: HistoryDone, ID: 43},
+	}
+	data, err := json.Marshal(events)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `[{"path":"/tmp/file","flags":"ItemCreated|ItemIsFile","id":42,"inode":1234},` +
+		`{"path":"","flags":"HistoryDone","id":43}]`
+	if string(data) != want {
+		t.Errorf("\nhave: %s\nwant: %s", data, want)
+	}
+
+	var have []Event
+	if err := json.Unmarshal(data, &have); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(have, events) {
+		t.Errorf("\nhave: %v\nwant: %v", have, events)
+	}
+
+	err = json.Unmarshal([]byte(`{"path":"/a","flags":"ItemUnknown"}`), &Event{})
+	if err == nil {
+		t.Error("no error for an unknown flag")
+	}
+}
+
+func TestEventString(t *testing.T) {
+	e := Event{Path: "/tmp/file", Flags: ItemCreated | ItemIsFile, ID: 42, Inode: 1234}
+	if have, want := e.String(), `ItemCreated|ItemIsFile "/tmp/file" id=42 inode=1234`; have != want {
+		t.Errorf("\nhave: %s\nwant: %s", have, want)
+	}
+	e = Event{Flags: HistoryDone, ID: 43}
+	if have, want := fmt.Sprint(e), `HistoryDone "" id=43`; have != want {
+		t.Errorf("\nhave: %s\nwant: %s", have, want)
+	}
+}
+
+func TestBatch(t *testing.T) {
+	events := []Event{
+		{Path: "/tmp/dir", Flags: ItemCreated | ItemIsDir, ID: 100, Inode: 7},
+		{Path: "/tmp/dir/file", Flags: ItemCreated | ItemIsFile, ID: 101, Inode: 8},
+		{Path: "/tmp/other", Flags: ItemRemoved | ItemIsFile, ID: 99},
+		{Flags: HistoryDone, ID: 1 << 63},
+	}
+
+	b := AppendBatch(nil, events)
+	// The encoding doesn't change.
+	want := "0104" +
+		"c801" + "808208" + "07" + "0008" + "2f746d702f646972" +
+		"02" + "808204" + "08" + "0805" + "2f66696c65" +
+		"03" + "808404" + "00" + "0505" + "6f74686572" +
+		"bafeffffffffffffff01" + "10" + "00" + "0000"
+	if have := fmt.Sprintf("%x", b); have != want {
+		t.Errorf("\nhave: %s\nwant: %s", have, want)
+	}
+
+	// Batches can be concatenated.
+	b = AppendBatch(b, events[:1])
+	have, n, err := DecodeBatch(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(have, events) {
+		t.Errorf("\nhave: %v\nwant: %v", have, events)
+	}
+	have, m, err := DecodeBatch(b[n:])
+	if err != nil || n+m != len(b) || !reflect.DeepEqual(have, events[:1]) {
+		t.Errorf("second batch is %v, %v", have, err)
+	}
+
+	have, n, err = DecodeBatch(AppendBatch(nil, nil))
+	if err != nil || n != 2 || len(have) != 0 {
+		t.Errorf("empty batch is %v, %d, %v", have, n, err)
+	}
+}
+
+func TestDecodeBatchInvalid(t *testing.T) {
+	b := AppendBatch(nil, []Event{
+		{Path: "/tmp/dir", Flags: ItemCreated | ItemIsDir, ID: 100, Inode: 7},
+		{Path: "/tmp/dir/file", Flags: ItemCreated | ItemIsFile, ID: 101, Inode: 8},
+	})
+	for i := 0; i < len(b); i++ {
+		if _, _, err := DecodeBatch(b[:i]); !errors.Is(err, ErrInvalidBatch) {
+			t.Errorf("%d bytes: error is %v", i, err)
+		}
+	}
+
+	for _, b := range [][]byte{
+		{2, 0},                   // version
+		{1, 1, 0, 0, 0, 1, 0},    // shares more than the previous path
+		{1, 1, 0, 0, 0, 0, 10},   // path longer than the data
+		{1, 0xff, 0xff, 0xff, 1}, // too many events
+		{1, 1, 0, 0x80, 0x80, 0x80, 0x80, 0x10, 0, 0, 0}, // flags
+	} {
+		if _, _, err := DecodeBatch(b); !errors.Is(err, ErrInvalidBatch) {
+			t.Errorf("%x: error is %v", b, err)
+		}
+	}
+}
@@ -0,0 +1,300 @@
+package fsevents
+
+import (
+	"fmt"
+	"io/fs"
+)
+
+// Op is an operation on an item, decoded from the flags of its events.
+type Op int
+
+const (
+	// OpCreate is the creation of the item.
+	OpCreate Op = iota + 1
+
+	// OpRenameTo is an item being renamed to the path.
+	OpRenameTo
+
+	// OpRenameFrom is the item being renamed away from the path.
+	OpRenameFrom
+
+	// OpRemove is the removal of the item.
+	OpRemove
+
+	// OpModify is a change to the contents of the item.
+	OpModify
+
+	// OpChangeMeta is a change to the metadata of the item: its mode,
+	// owner, extended attributes or Finder information.
+	OpChangeMeta
+)
+
+func (o Op) String() string {
+	switch o {
+	case OpCreate:
+		return "Create"
+	case OpRenameTo:
+		return "RenameTo"
+	case OpRenameFrom:
+		return "RenameFrom"
+	case OpRemove:
+		return "Remove"
+	case OpModify:
+		return "Modify"
+	case OpChangeMeta:
+		return "ChangeMeta"
+	default:
+		return fmt.Sprintf("Op(%d)", int(o))
+	}
+}
+
+// arrival reports whether the op puts an item at the path.
+func (o Op) arrival() bool {
+	return o == OpCreate || o == OpRenameTo
+}
+
+// State is the state of a path after its events.
+type State int
+
+const (
+	// StateExists is an item that's still at the path, the one the
+	// events were for.
+	StateExists State = iota
+
+	// StateGone is a path that no longer exists.
+	StateGone
+
+	// StateReplaced is a path with an item that took the place of the one
+	// the events were for: it was removed or renamed away, or the item
+	// that's there is of another type.
+	StateReplaced
+)
+
+func (s State) String() string {
+	switch s {
+	case StateExists:
+		return "Exists"
+	case StateGone:
+		return "Gone"
+	case StateReplaced:
+		return "Replaced"
+	default:
+		return fmt.Sprintf("State(%d)", int(s))
+	}
+}
+
+// Decoded holds the operations decoded from the events for a path, and the
+// state of the path after them.
+type Decoded struct {
+	Ops   []Op
+	State State
+}
+
+// metaFlags are the flags for changes to the metadata of an item.
+const metaFlags = ItemInodeMetaMod | ItemChangeOwner | ItemXattrMod | ItemFinderInfoMod
+
+// Decode decodes the flags of e, which FSEvents may have coalesced from
+// several operations, such as ItemCreated|ItemModified|ItemRemoved for a file
+// that was created, written and removed. info is the result of Lstat on the
+// path after the event was received, or nil if it doesn't exist.
+//
+// The operations are put in an order that ends with the path in the state
+// info shows: an item can only be created or renamed to the path if
+// there's none there, and removed or renamed away if there's one. Where more
+// than one order is possible, creation comes first, then renames, then
+// removal. ItemModified and the metadata flags are decoded as changes to the
+// last item that was put at the path, or the first one if none was.
+func Decode(e Event, info fs.FileInfo) Decoded {
+	return DecodeEvents([]Event{e}, info)
+}
+
+// DecodeEvents decodes the events for a single path, in the order of their
+// IDs, like Decode. The order of the events is kept, so two ItemRenamed
+// events for a path that exists, as for a file that was overwritten by a
+// rename, decode as RenameFrom followed by RenameTo.
+func DecodeEvents(events []Event, info fs.FileInfo) Decoded {
+	segs := make([]opCounts, len(events))
+	for i, e := range events {
+		segs[i] = newOpCounts(e.Flags)
+	}
+
+	exists := info != nil
+	ops, ok := orderOps(segs, events, [2]bool{!exists, exists})
+	if !ok {
+		// The events don't lead to the state of the path, as it
+		// changed again after them.
+		ops, ok = orderOps(segs, events, [2]bool{true, true})
+	}
+	if !ok {
+		// Events are missing in between, so order each one by itself.
+		ops = nil
+		for i := range events {
+			o, _ := orderOps(segs[i:i+1], events[i:i+1], [2]bool{true, true})
+			ops = append(ops, o...)
+		}
+	}
+
+	d := Decoded{Ops: ops, State: StateExists}
+	switch {
+	case !exists:
+		d.State = StateGone
+	case departed(ops) || !sameType(events, info):
+		d.State = StateReplaced
+	}
+	return d
+}
+
+// opCounts counts the operations of an event that put an item at the path or
+// take it away.
+type opCounts struct {
+	created, renamed, removed int
+}
+
+func newOpCounts(f EventFlags) opCounts {
+	var n opCounts
+	if f&ItemCreated != 0 {
+		n.created++
+	}
+	if f&ItemRenamed != 0 {
+		n.renamed++
+	}
+	if f&ItemRemoved != 0 {
+		n.removed++
+	}
+	return n
+}
+
+func (n opCounts) len() int {
+	return n.created + n.renamed + n.removed
+}
+
+// end returns whether there's an item at the path after the operations, when
+// there is one before them if present is set. It returns false if the
+// operations can't happen in any order from there: they have to alternate
+// between putting an item at the path and taking it away, and renames can do
+// either.
+func (n opCounts) end(present bool) (after, ok bool) {
+	l := n.len()
+	arrivals := l / 2
+	if !present {
+		arrivals = (l + 1) / 2
+	}
+	if n.created > arrivals || n.removed > l-arrivals {
+		return false, false
+	}
+	return present != (l%2 == 1), true
+}
+
+// take returns the counts without op.
+func (n opCounts) take(op Op) (opCounts, bool) {
+	switch op {
+	case OpCreate:
+		n.created--
+		return n, n.created >= 0
+	case OpRenameTo, OpRenameFrom:
+		n.renamed--
+		return n, n.renamed >= 0
+	case OpRemove:
+		n.removed--
+		return n, n.removed >= 0
+	}
+	return n, false
+}
+
+// orderOps orders the operations of the events, one segment each, so that the
+// path ends up in one of the states set in final, indexed by whether there's
+// an item. It returns false if there's no such order.
+func orderOps(segs []opCounts, events []Event, final [2]bool) ([]Op, bool) {
+	// starts[i] holds the states the operations from segs[i] on can
+	// start in and still end in final.
+	starts := make([][2]bool, len(segs)+1)
+	starts[len(segs)] = final
+	for i := len(segs) - 1; i >= 0; i-- {
+		for _, present := range []bool{false, true} {
+			if after, ok := segs[i].end(present); ok && starts[i+1][b2i(after)] {
+				starts[i][b2i(present)] = true
+			}
+		}
+	}
+	if !starts[0][0] && !starts[0][1] {
+		return nil, false
+	}
+
+	var (
+		ops     []Op
+		known   bool // whether the state is known yet
+		present bool
+	)
+	for i, n := range segs {
+		mods := len(ops) // where the changes go: after the last arrival
+		for n.len() > 0 {
+			for _, op := range []Op{OpCreate, OpRenameTo, OpRenameFrom, OpRemove} {
+				if known && present == op.arrival() {
+					continue
+				}
+				m, ok := n.take(op)
+				if !ok {
+					continue
+				}
+				if after, ok := m.end(op.arrival()); !ok || !starts[i+1][b2i(after)] {
+					continue
+				}
+				ops = append(ops, op)
+				if op.arrival() {
+					mods = len(ops)
+				}
+				n, known, present = m, true, op.arrival()
+				break
+			}
+		}
+
+		var changes []Op
+		if events[i].Flags&ItemModified != 0 {
+			changes = append(changes, OpModify)
+		}
+		if events[i].Flags&metaFlags != 0 {
+			changes = append(changes, OpChangeMeta)
+		}
+		ops = append(ops[:mods], append(changes, ops[mods:]...)...)
+	}
+	return ops, true
+}
+
+func b2i(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// departed reports whether an item was taken away from the path.
+func departed(ops []Op) bool {
+	for _, op := range ops {
+		if op == OpRenameFrom || op == OpRemove {
+			return true
+		}
+	}
+	return false
+}
+
+// sameType reports whether info is for the type of item the last of the events
+// is for, if it has one.
+func sameType(events []Event, info fs.FileInfo) bool {
+	var t EventFlags
+	switch m := info.Mode(); {
+	case m.IsDir():
+		t = ItemIsDir
+	case m&fs.ModeSymlink != 0:
+		t = ItemIsSymlink
+	case m.IsRegular():
+		t = ItemIsFile
+	default:
+		return true
+	}
+	for i := len(events) - 1; i >= 0; i-- {
+		if f := events[i].Flags & itemTypes; f != 0 {
+			return f&t != 0
+		}
+	}
+	return true
+}
@@ -0,0 +1,98 @@
+//go:build darwin || linux
+
+package fsevents
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func formatDecoded(d Decoded) string {
+	var ops []string
+	for _, op := range d.Ops {
+		ops = append(ops, op.String())
+	}
+	return fmt.Sprintf("%s %s", strings.Join(ops, ","), d.State)
+}
+
+func TestDecode(t *testing.T) {
+	tmp := t.TempDir()
+	touch(t, tmp, "file")
+	mkdir(t, tmp, "dir")
+	lstat := func(name string) fs.FileInfo {
+		info, err := os.Lstat(filepath.Join(tmp, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return info
+	}
+	var (
+		file = lstat("file")
+		dir  = lstat("dir")
+	)
+
+	tests := []struct {
+		name   string
+		events []EventFlags
+		info   fs.FileInfo
+		want   string
+	}{
+		{"created", []EventFlags{ItemCreated | ItemIsFile}, file, "Create Exists"},
+		{"modified", []EventFlags{ItemModified | ItemInodeMetaMod | ItemIsFile}, file, "Modify,ChangeMeta Exists"},
+		{"removed", []EventFlags{ItemRemoved | ItemIsFile}, nil, "Remove Gone"},
+
+		// TestMany: coalesced into a single event.
+		{"many", []EventFlags{ItemCreated | ItemModified | ItemRemoved | ItemIsFile}, nil, "Create,Modify,Remove Gone"},
+		{"many exists", []EventFlags{ItemCreated | ItemModified | ItemRemoved | ItemIsFile}, file, "Remove,Create,Modify Replaced"},
+
+		// bug-277, both as separate and coalesced events.
+		{"bug-277 /foo", []EventFlags{ItemCreated | ItemIsFile, ItemRemoved | ItemIsFile}, nil, "Create,Remove Gone"},
+		{"bug-277 /apple", []EventFlags{ItemCreated | ItemIsDir, ItemRenamed | ItemIsDir}, nil, "Create,RenameFrom Gone"},
+		{"bug-277 /apple coalesced", []EventFlags{ItemCreated | ItemRenamed | ItemIsDir}, nil, "Create,RenameFrom Gone"},
+		{"bug-277 /pear", []EventFlags{ItemRenamed | ItemIsDir, ItemRemoved | ItemIsDir}, nil, "RenameTo,Remove Gone"},
+		{"bug-277 /pear coalesced", []EventFlags{ItemRenamed | ItemRemoved | ItemIsDir}, nil, "RenameTo,Remove Gone"},
+
+		// rename-overwrite: macOS reports the overwritten file, inotify
+		// doesn't.
+		{"rename-overwrite /file", []EventFlags{ItemRenamed | ItemIsFile}, nil, "RenameFrom Gone"},
+		{"rename-overwrite /rename", []EventFlags{ItemRenamed | ItemIsFile, ItemRenamed | ItemIsFile}, file, "RenameFrom,RenameTo Replaced"},
+		{"rename-overwrite /rename linux", []EventFlags{ItemRenamed | ItemIsFile}, file, "RenameTo Exists"},
+
+		// overwrite-watched-file: the file is written to and removed after
+		// it was overwritten.
+		{"overwrite-watched-file", []EventFlags{
+			ItemRenamed | ItemIsFile, ItemRenamed | ItemIsFile,
+			ItemModified | ItemIsFile, ItemRemoved | ItemIsFile,
+		}, nil, "RenameFrom,RenameTo,Modify,Remove Gone"},
+		{"overwrite-watched-file linux", []EventFlags{
+			ItemRenamed | ItemIsFile, ItemModified | ItemIsFile, ItemRemoved | ItemIsFile,
+		}, nil, "RenameTo,Modify,Remove Gone"},
+		{"overwrite-watched-file coalesced", []EventFlags{
+			ItemRenamed | ItemModified | ItemRemoved | ItemIsFile,
+		}, nil, "RenameTo,Modify,Remove Gone"},
+
+		// The path changed again after the events.
+		{"removed exists", []EventFlags{ItemRemoved | ItemIsFile}, file, "Remove Replaced"},
+		{"created gone", []EventFlags{ItemCreated | ItemIsFile}, nil, "Create Gone"},
+		{"other type", []EventFlags{ItemModified | ItemIsDir}, file, "Modify Replaced"},
+		{"all", []EventFlags{ItemCreated | ItemRenamed | ItemRemoved | ItemIsDir}, dir, "Create,Remove,RenameTo Replaced"},
+
+		// Events are missing: the file was removed in between.
+		{"missing", []EventFlags{ItemCreated | ItemIsFile, ItemCreated | ItemIsFile}, file, "Create,Create Exists"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			events := make([]Event, len(tt.events))
+			for i, f := range tt.events {
+				events[i] = Event{Path: "/a", Flags: f, ID: uint64(i + 1)}
+			}
+			if have := formatDecoded(DecodeEvents(events, tt.info)); have != tt.want {
+				t.Errorf("\nhave: %s\nwant: %s", have, tt.want)
+			}
+		})
+	}
+}
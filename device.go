@@ -0,0 +1,21 @@
+//go:build darwin || linux
+
+package fsevents
+
+import (
+	"syscall"
+)
+
+// DeviceID identifies a device, like the st_dev field of a stat structure. It
+// has the full width of st_dev on every platform, unlike the int32 returned
+// by DeviceForPath, which truncates the 64-bit device numbers of Linux.
+type DeviceID uint64
+
+// DeviceIDForPath returns the ID of the device the file at path is on.
+func DeviceIDForPath(path string) (DeviceID, error) {
+	stat := syscall.Stat_t{}
+	if err := syscall.Lstat(path, &stat); err != nil {
+		return 0, err
+	}
+	return statDev(&stat), nil
+}
@@ -0,0 +1,66 @@
+//go:build darwin
+
+package fsevents
+
+import (
+	"fmt"
+	"path/filepath"
+	"syscall"
+)
+
+// DeviceUUID returns the UUID FSEvents uses to identify the device dev, as
+// returned by GetDeviceUUID. Event IDs for a device are only valid while its
+// UUID stays the same.
+func DeviceUUID(dev DeviceID) (string, error) {
+	uuid := GetDeviceUUID(int32(dev))
+	if uuid == "" {
+		return "", fmt.Errorf("fsevents: no UUID for device %d", dev)
+	}
+	return uuid, nil
+}
+
+// DeviceMountPoint returns where the device dev is mounted.
+func DeviceMountPoint(dev DeviceID) (string, error) {
+	n, err := syscall.Getfsstat(nil, mntNoWait)
+	if err != nil {
+		return "", fmt.Errorf("fsevents: getfsstat: %w", err)
+	}
+	mounts := make([]syscall.Statfs_t, n)
+	if n, err = syscall.Getfsstat(mounts, mntNoWait); err != nil {
+		return "", fmt.Errorf("fsevents: getfsstat: %w", err)
+	}
+	for _, m := range mounts[:n] {
+		// The st_dev of the files on a volume is f_fsid.val[0].
+		if DeviceID(uint32(m.Fsid.Val[0])) == dev {
+			return cString(m.Mntonname[:]), nil
+		}
+	}
+	return "", fmt.Errorf("fsevents: no mount for device %d", dev)
+}
+
+// deviceAbsPath returns a function that returns the absolute path of a path
+// relative to the root of the device dev.
+func deviceAbsPath(dev DeviceID) (func(rel string) (string, bool), error) {
+	mountPoint, err := DeviceMountPoint(dev)
+	if err != nil {
+		return nil, err
+	}
+	return func(rel string) (string, bool) {
+		return filepath.Join(mountPoint, rel), true
+	}, nil
+}
+
+// mntNoWait is MNT_NOWAIT from sys/mount.h: return the cached information
+// rather than waiting for every filesystem.
+const mntNoWait = 2
+
+func cString(b []int8) string {
+	s := make([]byte, 0, len(b))
+	for _, c := range b {
+		if c == 0 {
+			break
+		}
+		s = append(s, byte(c))
+	}
+	return string(s)
+}
@@ -0,0 +1,120 @@
+//go:build linux
+
+package fsevents
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"golang.org/x/sys/unix"
+)
+
+// GetDeviceUUID retrieves the UUID of the filesystem on the device, like it
+// does on macOS. It returns an empty string if there's none; use DeviceUUID
+// for the reason.
+func GetDeviceUUID(deviceID int32) string {
+	uuid, _ := DeviceUUID(DeviceID(uint32(deviceID)))
+	return uuid
+}
+
+// DeviceUUID returns the UUID of the filesystem on the device dev. An event ID
+// stored for a device is only meaningful while it has the same UUID.
+//
+// This is the UUID of the filesystem from /dev/disk/by-uuid if there is one,
+// or otherwise the filesystem ID from statfs(2) for filesystems without a
+// block device, such as btrfs subvolumes and network filesystems.
+func DeviceUUID(dev DeviceID) (string, error) {
+	d, err := hostDevices()
+	if err != nil {
+		return "", err
+	}
+	return d.uuid(uint64(dev))
+}
+
+// DeviceMountPoint returns where the root of the filesystem on the device dev
+// is mounted.
+func DeviceMountPoint(dev DeviceID) (string, error) {
+	mounts, err := readMountInfo()
+	if err != nil {
+		return "", fmt.Errorf("fsevents: %w", err)
+	}
+	return deviceMountPoint(mounts, uint64(dev))
+}
+
+// deviceAbsPath returns a function that returns the absolute path of a path
+// relative to the root of the filesystem on dev, and false if it isn't mounted.
+func deviceAbsPath(dev DeviceID) (func(rel string) (string, bool), error) {
+	mounts, err := readMountInfo()
+	if err != nil {
+		return nil, fmt.Errorf("fsevents: %w", err)
+	}
+	d, err := newDeviceMounts(mounts, uint64(dev))
+	if err != nil {
+		return nil, err
+	}
+	return d.abs, nil
+}
+
+// devices finds the identity of devices. The sources are fields so they can
+// be replaced in tests.
+type devices struct {
+	mounts []mountInfo
+	byUUID string                                    // directory of links to devices named by UUID
+	rdev   func(path string) (uint64, error)         // st_rdev of a device node
+	fsid   func(mountPoint string) ([2]int32, error) // f_fsid from statfs
+}
+
+func hostDevices() (*devices, error) {
+	mounts, err := readMountInfo()
+	if err != nil {
+		return nil, fmt.Errorf("fsevents: %w", err)
+	}
+	return &devices{
+		mounts: mounts,
+		byUUID: "/dev/disk/by-uuid",
+		rdev: func(path string) (uint64, error) {
+			var st unix.Stat_t
+			if err := unix.Stat(path, &st); err != nil {
+				return 0, err
+			}
+			return st.Rdev, nil
+		},
+		fsid: func(mountPoint string) ([2]int32, error) {
+			var st unix.Statfs_t
+			if err := unix.Statfs(mountPoint, &st); err != nil {
+				return [2]int32{}, err
+			}
+			return st.Fsid.Val, nil
+		},
+	}, nil
+}
+
+func (d *devices) uuid(dev uint64) (string, error) {
+	mountPoint, err := deviceMountPoint(d.mounts, dev)
+	if err != nil {
+		return "", err
+	}
+
+	// The links in by-uuid point to the device nodes.
+	if entries, err := os.ReadDir(d.byUUID); err == nil {
+		for _, e := range entries {
+			rdev, err := d.rdev(filepath.Join(d.byUUID, e.Name()))
+			if err == nil && rdev == dev {
+				return e.Name(), nil
+			}
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return "", fmt.Errorf("fsevents: %w", err)
+	}
+
+	fsid, err := d.fsid(mountPoint)
+	if err != nil {
+		return "", fmt.Errorf("fsevents: statfs %q: %w", mountPoint, err)
+	}
+	if fsid == [2]int32{} {
+		return "", fmt.Errorf("fsevents: no UUID for the filesystem mounted at %q", mountPoint)
+	}
+	return fmt.Sprintf("%08x-%08x", uint32(fsid[0]), uint32(fsid[1])), nil
+}
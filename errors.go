@@ -0,0 +1,79 @@
+package fsevents
+
+import (
+	"errors"
+	"io/fs"
+)
+
+var (
+	// ErrTooManyPaths is returned by Start if the stream watches more
+	// paths than the platform supports: 4096 with FSEvents, or the
+	// inotify watch limit on Linux.
+	ErrTooManyPaths = errors.New("fsevents: too many paths")
+
+	// ErrPathNotFound matches the errors for paths that don't exist. It's
+	// only returned by the Linux backends and PollBackend: FSEvents accepts
+	// paths that don't exist yet, and reports their events once they're
+	// created.
+	ErrPathNotFound = errors.New("fsevents: path not found")
+
+	// ErrPermission matches the errors for paths that can't be watched,
+	// or watching that isn't allowed. Like ErrPathNotFound, it's not
+	// returned by the native backend on macOS, which doesn't check the
+	// paths.
+	ErrPermission = errors.New("fsevents: permission denied")
+
+	// ErrAlreadyStarted is returned by Start if the stream is already
+	// started.
+	ErrAlreadyStarted = errors.New("fsevents: already started")
+
+	// ErrStreamEnded is returned by Client.Start and Client.Err when the
+	// stream served by the Server was stopped.
+	ErrStreamEnded = errors.New("fsevents: stream ended")
+
+	// ErrInvalidBatch is returned by DecodeBatch for data that isn't a
+	// batch encoded by AppendBatch.
+	ErrInvalidBatch = errors.New("fsevents: invalid batch")
+)
+
+// PathError is an error for a path of a stream. It matches ErrPathNotFound
+// and ErrPermission, as well as fs.ErrNotExist and fs.ErrPermission, with
+// errors.Is:
+//
+//	if errors.Is(err, fsevents.ErrPathNotFound) {
+//		...
+//	}
+type PathError struct {
+	Op   string
+	Path string
+	Err  error
+}
+
+func (e *PathError) Error() string {
+	return "fsevents: " + e.Op + " " + e.Path + ": " + e.Err.Error()
+}
+
+func (e *PathError) Unwrap() error {
+	return e.Err
+}
+
+// Is reports whether e matches ErrPathNotFound or ErrPermission.
+func (e *PathError) Is(target error) bool {
+	switch target {
+	case ErrPathNotFound:
+		return errors.Is(e.Err, fs.ErrNotExist)
+	case ErrPermission:
+		return errors.Is(e.Err, fs.ErrPermission)
+	}
+	return false
+}
+
+// newPathError returns a PathError for an error about path, using the
+// operation and error of an fs.PathError.
+func newPathError(op, path string, err error) error {
+	var pe *fs.PathError
+	if errors.As(err, &pe) {
+		op, path, err = pe.Op, pe.Path, pe.Err
+	}
+	return &PathError{Op: op, Path: path, Err: err}
+}
@@ -25,23 +25,17 @@ func main() {
 	log.Println(fsevents.EventIDForDeviceBeforeTime(dev, time.Now()))
 
 	es := &fsevents.EventStream{
-		Paths:   []string{path},
-		Latency: 500 * time.Millisecond,
-		Device:  dev,
-		Flags:   fsevents.FileEvents | fsevents.WatchRoot}
+		Paths:      []string{path},
+		Latency:    500 * time.Millisecond,
+		Device:     dev,
+		Flags:      fsevents.FileEvents | fsevents.WatchRoot,
+		FlatEvents: make(chan fsevents.Event),
+	}
 	es.Start()
-	ec := es.Events
+	go logEvents(es.FlatEvents)
 
 	log.Println("Device UUID", fsevents.GetDeviceUUID(dev))
 
-	go func() {
-		for msg := range ec {
-			for _, event := range msg {
-				logEvent(event)
-			}
-		}
-	}()
-
 	in := bufio.NewReader(os.Stdin)
 
 	if false {
@@ -59,6 +53,7 @@ func main() {
 		in.ReadString('\n')
 		es.Resume = true
 		es.Start()
+		go logEvents(es.FlatEvents)
 
 		log.Print("Restarted, press enter to quit")
 		in.ReadString('\n')
@@ -66,35 +61,13 @@ func main() {
 	}
 }
 
-var noteDescription = map[fsevents.EventFlags]string{
-	fsevents.MustScanSubDirs: "MustScanSubdirs",
-	fsevents.UserDropped:     "UserDropped",
-	fsevents.KernelDropped:   "KernelDropped",
-	fsevents.EventIDsWrapped: "EventIDsWrapped",
-	fsevents.HistoryDone:     "HistoryDone",
-	fsevents.RootChanged:     "RootChanged",
-	fsevents.Mount:           "Mount",
-	fsevents.Unmount:         "Unmount",
-
-	fsevents.ItemCreated:       "Created",
-	fsevents.ItemRemoved:       "Removed",
-	fsevents.ItemInodeMetaMod:  "InodeMetaMod",
-	fsevents.ItemRenamed:       "Renamed",
-	fsevents.ItemModified:      "Modified",
-	fsevents.ItemFinderInfoMod: "FinderInfoMod",
-	fsevents.ItemChangeOwner:   "ChangeOwner",
-	fsevents.ItemXattrMod:      "XAttrMod",
-	fsevents.ItemIsFile:        "IsFile",
-	fsevents.ItemIsDir:         "IsDir",
-	fsevents.ItemIsSymlink:     "IsSymLink",
+// logEvents logs the events until the stream is stopped.
+func logEvents(events <-chan fsevents.Event) {
+	for event := range events {
+		logEvent(event)
+	}
 }
 
 func logEvent(event fsevents.Event) {
-	note := ""
-	for bit, description := range noteDescription {
-		if event.Flags&bit == bit {
-			note += description + " "
-		}
-	}
-	log.Printf("EventID: %d Path: %s Flags: %s", event.ID, event.Path, note)
+	log.Printf("EventID: %d Path: %s Inode: %d Flags: %s", event.ID, event.Path, event.Inode, event.Flags)
 }
@@ -0,0 +1,424 @@
+package fsevents
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// FakeStream is an in-memory stand-in for EventStream, for testing code that
+// consumes events without touching the filesystem or waiting for the OS.
+// It mirrors the Events, Paths, Flags, Latency, Resume and EventID fields of
+// EventStream and its Start, StartContext, Flush, Stop, Restart, Ack and
+// LatestEventID methods, and only delivers the events that are injected:
+//
+//	s := &FakeStream{Paths: []string{"/tmp"}, Flags: FileEvents}
+//	s.Start()
+//	s.Inject([]Event{{Path: "/tmp/file", Flags: ItemCreated | ItemIsFile}})
+//	s.Flush(true) // Returns after the consumer received the batch.
+//
+// Events are delivered in the order they're injected, one batch per Inject.
+// With a Latency, they're grouped the way FSEvents does instead; set Clock to
+// control the time with a virtual clock.
+type FakeStream struct {
+	// Accessed atomically, and first for alignment on 32-bit platforms.
+	latestID uint64 // ID of the last event delivered
+	ackedID  uint64 // ID passed to Ack
+
+	// Events holds the channel on which events will be sent.
+	// It's initialized by FakeStream.Start if nil, and replaced by a
+	// new channel with the same capacity if it was closed by Stop.
+	Events chan []Event
+
+	// Paths holds the set of paths to watch. They're used as the paths
+	// of the events sent by InjectUserDropped.
+	Paths []string
+
+	// Flags specifies what events to receive on the stream. Only
+	// WatchRoot and NoDefer have an effect: RootChanged events are only
+	// sent if WatchRoot is set.
+	Flags CreateFlags
+
+	// Latency holds how long injected events are held back to group
+	// them, as described for EventStream.
+	Latency time.Duration
+
+	// Clock tells the time for Latency. It defaults to the real time.
+	Clock Clock
+
+	// Resume is not used by FakeStream, but is set by Restart like it is
+	// for EventStream.
+	Resume bool
+
+	// EventID holds the most recent event ID.
+	//
+	// NOTE: this is updated asynchronously, the same way it is for
+	// EventStream. It's safe to access after Flush(true) returns.
+	EventID uint64
+
+	lifecycle sync.Mutex // serializes Start, Stop and Restart
+
+	mu      sync.Mutex
+	queue   []fakeBatch
+	wake    chan struct{} // signals the sender that queue isn't empty
+	done    chan struct{} // closed by Stop
+	exited  chan struct{} // closed when the sender exits
+	lastID  uint64        // ID of the last injected event
+	started bool
+	batcher *Batcher // events held back for Latency
+	running *Run     // set by StartContext
+	closed  bool     // Events was closed by Stop
+}
+
+// fakeBatch is an item in the queue of a FakeStream: either events, a flush
+// barrier, or neither to only wake the sender so it resets its timer.
+type fakeBatch struct {
+	events []Event
+	ack    chan struct{}
+}
+
+// Start starts delivering injected events. This creates Events if it's not
+// already a valid channel.
+func (f *FakeStream) Start() error {
+	f.lifecycle.Lock()
+	defer f.lifecycle.Unlock()
+
+	return f.start()
+}
+
+func (f *FakeStream) start() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.started {
+		return ErrAlreadyStarted
+	}
+	if f.Events == nil {
+		f.Events = make(chan []Event)
+	} else if f.closed {
+		// Replace the channel closed by Stop with a similar one.
+		f.Events = make(chan []Event, cap(f.Events))
+		f.closed = false
+	}
+	if f.lastID < f.EventID {
+		f.lastID = f.EventID
+	}
+	atomic.StoreUint64(&f.latestID, f.EventID)
+	f.wake = make(chan struct{}, 1)
+	f.done = make(chan struct{})
+	f.exited = make(chan struct{})
+	f.started = true
+	if len(f.queue) > 0 {
+		f.wake <- struct{}{}
+	}
+
+	go f.run(f.wake, f.done, f.exited)
+	return nil
+}
+
+// Flush delivers the events held back for Latency right away. If sync is
+// true, it waits until all events injected so far have been received from
+// Events.
+func (f *FakeStream) Flush(sync bool) {
+	ack := make(chan struct{})
+	f.mu.Lock()
+	if events := f.batch().Flush(); events != nil {
+		f.push(fakeBatch{events: events})
+	}
+	if !sync || !f.started {
+		f.mu.Unlock()
+		return
+	}
+	exited := f.exited
+	f.push(fakeBatch{ack: ack})
+	f.mu.Unlock()
+
+	select {
+	case <-ack:
+	case <-exited:
+	}
+}
+
+// StartContext starts delivering injected events until ctx is done, like
+// EventStream.StartContext.
+func (f *FakeStream) StartContext(ctx context.Context) (*Run, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	f.lifecycle.Lock()
+	if err := f.start(); err != nil {
+		f.lifecycle.Unlock()
+		return nil, err
+	}
+	r := newRun()
+	f.running = r
+	f.lifecycle.Unlock()
+
+	go func() {
+		select {
+		case <-ctx.Done():
+			f.lifecycle.Lock()
+			if f.running == r {
+				f.stop()
+				f.close(ctx.Err())
+			}
+			f.lifecycle.Unlock()
+		case <-r.done:
+		}
+	}()
+	return r, nil
+}
+
+// Stop stops delivering events and closes Events, like EventStream.Stop.
+// Events that were injected but not yet delivered are kept, and delivered on
+// a new channel if the stream is started again.
+func (f *FakeStream) Stop() {
+	f.lifecycle.Lock()
+	defer f.lifecycle.Unlock()
+
+	if f.stop() {
+		f.close(nil)
+	}
+}
+
+// stop stops the sender, and returns whether the stream was started.
+func (f *FakeStream) stop() bool {
+	f.mu.Lock()
+	if !f.started {
+		f.mu.Unlock()
+		return false
+	}
+	f.started = false
+	close(f.done)
+	exited := f.exited
+	f.mu.Unlock()
+
+	<-exited
+	return true
+}
+
+// close closes Events after the stream was stopped, and finishes the Run of
+// StartContext with err.
+func (f *FakeStream) close(err error) {
+	f.mu.Lock()
+	close(f.Events)
+	f.closed = true
+	f.mu.Unlock()
+
+	if f.running != nil {
+		f.running.finish(err)
+		f.running = nil
+	}
+}
+
+// Restart restarts the stream, like EventStream.Restart.
+func (f *FakeStream) Restart() error {
+	f.lifecycle.Lock()
+	defer f.lifecycle.Unlock()
+
+	f.stop()
+	if id := atomic.LoadUint64(&f.ackedID); id != 0 {
+		f.EventID = id
+	}
+	f.Resume = true
+	return f.start()
+}
+
+// LatestEventID returns the ID of the last event delivered on Events, like
+// EventStream.LatestEventID.
+func (f *FakeStream) LatestEventID() uint64 {
+	return atomic.LoadUint64(&f.latestID)
+}
+
+// Ack acknowledges that the events up to id have been processed, like
+// EventStream.Ack.
+func (f *FakeStream) Ack(id uint64) {
+	atomic.StoreUint64(&f.ackedID, id)
+}
+
+// AckedEventID returns the ID passed to Ack, or 0 if it wasn't called.
+func (f *FakeStream) AckedEventID() uint64 {
+	return atomic.LoadUint64(&f.ackedID)
+}
+
+// Inject queues a batch of events for delivery. Events with a zero ID are
+// given the next ID, except for RootChanged events, which FSEvents always
+// sends with a zero ID.
+func (f *FakeStream) Inject(events []Event) {
+	batch := make([]Event, len(events))
+	copy(batch, events)
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for i := range batch {
+		switch {
+		case batch[i].Flags&RootChanged != 0:
+		case batch[i].ID == 0:
+			f.lastID++
+			batch[i].ID = f.lastID
+		case batch[i].ID > f.lastID:
+			f.lastID = batch[i].ID
+		}
+	}
+	if events := f.batch().Add(batch); events != nil {
+		f.push(fakeBatch{events: events})
+	} else {
+		// Reset the timer.
+		f.push(fakeBatch{})
+	}
+}
+
+// InjectHistoryDone injects the sentinel event that marks the end of the
+// historical events sent for EventStream.Resume.
+func (f *FakeStream) InjectHistoryDone() {
+	f.Inject([]Event{{Flags: HistoryDone}})
+}
+
+// InjectUserDropped injects the events FSEvents sends for all the paths if
+// the client didn't keep up and events were dropped.
+func (f *FakeStream) InjectUserDropped() {
+	events := make([]Event, 0, len(f.Paths))
+	for _, p := range f.Paths {
+		events = append(events, Event{Path: p, Flags: MustScanSubDirs | UserDropped})
+	}
+	f.Inject(events)
+}
+
+// InjectRootChanged injects the event sent when path, one of the paths being
+// watched, or one of its parents changed. Like FSEvents it's only sent if
+// Flags has WatchRoot set.
+func (f *FakeStream) InjectRootChanged(path string) {
+	if f.Flags&WatchRoot == 0 {
+		return
+	}
+	f.Inject([]Event{{Path: path, Flags: RootChanged}})
+}
+
+// InjectMount injects the event sent when a volume is mounted on path.
+func (f *FakeStream) InjectMount(path string) {
+	f.Inject([]Event{{Path: path, Flags: Mount}})
+}
+
+// InjectUnmount injects the event sent when a volume is unmounted from path.
+func (f *FakeStream) InjectUnmount(path string) {
+	f.Inject([]Event{{Path: path, Flags: Unmount}})
+}
+
+// InjectEventIDsWrapped injects the event sent when the 64-bit event ID
+// counter wrapped around. The IDs of events injected after it start again
+// from 1.
+func (f *FakeStream) InjectEventIDsWrapped() {
+	f.mu.Lock()
+	f.lastID = 0
+	f.mu.Unlock()
+	f.Inject([]Event{{Flags: EventIDsWrapped}})
+}
+
+// batch returns the Batcher for the current Latency, Flags and Clock; f.mu
+// must be held.
+func (f *FakeStream) batch() *Batcher {
+	if f.batcher == nil {
+		f.batcher = &Batcher{}
+	}
+	f.batcher.Latency = f.Latency
+	f.batcher.NoDefer = f.Flags&NoDefer != 0
+	f.batcher.Clock = f.Clock
+	return f.batcher
+}
+
+// push adds b to the queue; f.mu must be held.
+func (f *FakeStream) push(b fakeBatch) {
+	f.queue = append(f.queue, b)
+	if f.started {
+		select {
+		case f.wake <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// pop removes the first item from the queue.
+func (f *FakeStream) pop() (fakeBatch, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if len(f.queue) == 0 {
+		return fakeBatch{}, false
+	}
+	b := f.queue[0]
+	f.queue = f.queue[1:]
+	return b, true
+}
+
+// unpop puts b back at the front of the queue.
+func (f *FakeStream) unpop(b fakeBatch) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.queue = append([]fakeBatch{b}, f.queue...)
+}
+
+// run delivers the queued batches, and the held back events once they're
+// due, until the stream is stopped.
+func (f *FakeStream) run(wake, done, exited chan struct{}) {
+	defer close(exited)
+
+	f.mu.Lock()
+	timer := batchTimer{b: f.batch()}
+	f.mu.Unlock()
+	defer timer.stop()
+
+	for {
+		f.mu.Lock()
+		timer.reset()
+		f.mu.Unlock()
+
+		select {
+		case <-done:
+			return
+		case <-wake:
+		case <-timer.C():
+			f.mu.Lock()
+			if events := f.batch().Due(); events != nil {
+				f.queue = append(f.queue, fakeBatch{events: events})
+			}
+			f.mu.Unlock()
+		}
+
+		for {
+			b, ok := f.pop()
+			if !ok {
+				break
+			}
+			if b.ack != nil {
+				close(b.ack)
+				continue
+			}
+			if b.events == nil {
+				continue
+			}
+
+			var last uint64
+			for _, e := range b.events {
+				if e.ID != 0 {
+					last = e.ID
+				}
+			}
+			select {
+			case f.Events <- b.events:
+				// Like EventStream, EventID is only updated once the
+				// batch is delivered.
+				if last != 0 {
+					f.EventID = last
+					atomic.StoreUint64(&f.latestID, last)
+				}
+			case <-done:
+				f.unpop(b)
+				return
+			}
+		}
+	}
+}
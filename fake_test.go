@@ -0,0 +1,255 @@
+package fsevents
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestFakeStream(t *testing.T) {
+	s := &FakeStream{
+		Paths:   []string{"/a", "/b"},
+		Flags:   FileEvents,
+		EventID: 41,
+		Events:  make(chan []Event, 16),
+	}
+	if err := s.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Stop()
+
+	s.Inject([]Event{
+		{Path: "/a/file", Flags: ItemCreated | ItemIsFile},
+		{Path: "/a/file", Flags: ItemModified | ItemIsFile},
+	})
+	s.InjectHistoryDone()
+	s.InjectUserDropped()
+	s.InjectRootChanged("/a") // Not sent without WatchRoot.
+	s.InjectMount("/a/mnt")
+	s.InjectUnmount("/a/mnt")
+	s.Inject([]Event{{Path: "/b/file", Flags: ItemRemoved | ItemIsFile, ID: 100}})
+	s.InjectEventIDsWrapped()
+	s.Inject([]Event{{Path: "/b/new", Flags: ItemCreated | ItemIsFile}})
+	s.Flush(true)
+
+	if s.EventID != 2 || s.LatestEventID() != 2 {
+		t.Errorf("EventID is %d and LatestEventID %d, want 2", s.EventID, s.LatestEventID())
+	}
+
+	want := [][]Event{
+		{{Path: "/a/file", Flags: ItemCreated | ItemIsFile, ID: 42},
+			{Path: "/a/file", Flags: ItemModified | ItemIsFile, ID: 43}},
+		{{Flags: HistoryDone, ID: 44}},
+		{{Path: "/a", Flags: MustScanSubDirs | UserDropped, ID: 45},
+			{Path: "/b", Flags: MustScanSubDirs | UserDropped, ID: 46}},
+		{{Path: "/a/mnt", Flags: Mount, ID: 47}},
+		{{Path: "/a/mnt", Flags: Unmount, ID: 48}},
+		{{Path: "/b/file", Flags: ItemRemoved | ItemIsFile, ID: 100}},
+		{{Flags: EventIDsWrapped, ID: 1}},
+		{{Path: "/b/new", Flags: ItemCreated | ItemIsFile, ID: 2}},
+	}
+	if len(s.Events) != len(want) {
+		t.Fatalf("have %d batches, want %d", len(s.Events), len(want))
+	}
+	for i, w := range want {
+		have := <-s.Events
+		if len(have) != len(w) {
+			t.Fatalf("batch %d: have %v, want %v", i, have, w)
+		}
+		for j := range w {
+			if have[j] != w[j] {
+				t.Errorf("batch %d event %d: have %#v, want %#v", i, j, have[j], w[j])
+			}
+		}
+	}
+}
+
+func TestFakeStreamRootChanged(t *testing.T) {
+	s := &FakeStream{Paths: []string{"/a"}, Flags: WatchRoot}
+	s.Start()
+	defer s.Stop()
+
+	go s.InjectRootChanged("/a")
+	have := <-s.Events
+	if len(have) != 1 || have[0] != (Event{Path: "/a", Flags: RootChanged}) {
+		t.Errorf("have %#v", have)
+	}
+}
+
+func TestFakeStreamFlush(t *testing.T) {
+	s := &FakeStream{}
+	s.Start()
+
+	var received int
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for msg := range s.Events {
+			received += len(msg)
+		}
+	}()
+
+	for i := 0; i < 100; i++ {
+		s.Inject([]Event{{Path: "/file", Flags: ItemModified | ItemIsFile}})
+	}
+	s.Flush(true)
+	s.Stop()
+	<-done
+
+	if received != 100 {
+		t.Errorf("received %d events before Flush(true) returned, want 100", received)
+	}
+}
+
+func TestFakeStreamRestart(t *testing.T) {
+	s := &FakeStream{Events: make(chan []Event, 16)}
+
+	// Injected before Start and while stopped.
+	s.Inject([]Event{{Path: "/1"}})
+	s.Start()
+	s.Flush(true)
+	first := s.Events
+	s.Stop()
+	s.Inject([]Event{{Path: "/2"}})
+	if err := s.Restart(); err != nil {
+		t.Fatal(err)
+	}
+	s.Inject([]Event{{Path: "/3"}})
+	if err := s.Restart(); err != nil {
+		t.Fatal(err)
+	}
+	s.Flush(true)
+	defer s.Stop()
+
+	if !s.Resume {
+		t.Error("Resume not set by Restart")
+	}
+	// Stop closed the first channel, while Restart kept the new one.
+	if len(first) != 1 || len(s.Events) != 2 || s.EventID != 3 {
+		t.Errorf("have %d and %d batches with EventID %d; want 1, 2 and 3", len(first), len(s.Events), s.EventID)
+	}
+
+	s.Ack(1)
+	if err := s.Restart(); err != nil {
+		t.Fatal(err)
+	}
+	if s.EventID != 1 || s.AckedEventID() != 1 {
+		t.Errorf("EventID is %d after Restart, want the acknowledged 1", s.EventID)
+	}
+}
+
+func TestFakeStreamEventID(t *testing.T) {
+	s := &FakeStream{}
+	s.Start()
+	s.Inject([]Event{{Path: "/1"}})
+	// Give the stream time to block sending the batch.
+	time.Sleep(10 * time.Millisecond)
+	s.Stop()
+
+	// The batch wasn't delivered, so EventID wasn't updated.
+	if s.EventID != 0 {
+		t.Errorf("EventID is %d after Stop, want 0", s.EventID)
+	}
+
+	s.Start()
+	defer s.Stop()
+	have := <-s.Events
+	if len(have) != 1 || have[0].ID != 1 {
+		t.Errorf("have %v", have)
+	}
+	s.Flush(true)
+	if s.EventID != 1 {
+		t.Errorf("EventID is %d, want 1", s.EventID)
+	}
+}
+
+func TestFakeStreamLatency(t *testing.T) {
+	receive := func(t *testing.T, s *FakeStream) []Event {
+		t.Helper()
+		select {
+		case msg := <-s.Events:
+			return msg
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out")
+			return nil
+		}
+	}
+
+	t.Run("trailing", func(t *testing.T) {
+		clock := newVirtualClock()
+		s := &FakeStream{Latency: time.Second, Clock: clock}
+		s.Start()
+		defer s.Stop()
+
+		s.Inject([]Event{{Path: "/a"}})
+		clock.Advance(500 * time.Millisecond)
+		s.Inject([]Event{{Path: "/b"}})
+		clock.Advance(500 * time.Millisecond)
+		if have := receive(t, s); len(have) != 2 || have[0].Path != "/a" || have[1].Path != "/b" {
+			t.Errorf("have %v", have)
+		}
+	})
+
+	t.Run("leading", func(t *testing.T) {
+		clock := newVirtualClock()
+		s := &FakeStream{Latency: time.Second, Flags: NoDefer, Clock: clock}
+		s.Start()
+		defer s.Stop()
+
+		s.Inject([]Event{{Path: "/a"}})
+		if have := receive(t, s); len(have) != 1 || have[0].Path != "/a" {
+			t.Errorf("have %v", have)
+		}
+		clock.Advance(200 * time.Millisecond)
+		s.Inject([]Event{{Path: "/b"}})
+		s.Inject([]Event{{Path: "/c"}})
+		clock.Advance(800 * time.Millisecond)
+		if have := receive(t, s); len(have) != 2 || have[0].Path != "/b" || have[1].Path != "/c" {
+			t.Errorf("have %v", have)
+		}
+	})
+
+	t.Run("flush", func(t *testing.T) {
+		s := &FakeStream{Latency: time.Hour, Clock: newVirtualClock(), Events: make(chan []Event, 1)}
+		s.Start()
+		defer s.Stop()
+
+		s.Inject([]Event{{Path: "/a"}})
+		s.Flush(true)
+		if len(s.Events) != 1 {
+			t.Error("not delivered by Flush")
+		}
+	})
+}
+
+func TestFakeStreamStartContext(t *testing.T) {
+	s := &FakeStream{}
+	ctx, cancel := context.WithCancel(context.Background())
+	run, err := s.StartContext(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s.Inject([]Event{{Path: "/a"}})
+	cancel()
+	if err := run.Wait(); !errors.Is(err, context.Canceled) {
+		t.Errorf("Wait returned %v, want %v", err, context.Canceled)
+	}
+	if _, ok := <-s.Events; ok {
+		t.Error("Events wasn't closed")
+	}
+
+	// The batch that wasn't received is delivered on a new channel.
+	run, err = s.StartContext(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if have := <-s.Events; len(have) != 1 || have[0].Path != "/a" {
+		t.Errorf("have %v, want the batch injected before", have)
+	}
+	s.Stop()
+	if err := run.Wait(); err != nil {
+		t.Errorf("Wait returned %v after Stop", err)
+	}
+}
@@ -0,0 +1,277 @@
+//go:build linux
+
+package fsevents
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// fanotifyMask is the set of fanotify events requested for the filesystem.
+const fanotifyMask = unix.FAN_CREATE | unix.FAN_DELETE | unix.FAN_MODIFY |
+	unix.FAN_ATTRIB | unix.FAN_MOVED_FROM | unix.FAN_MOVED_TO | unix.FAN_ONDIR
+
+// fanotify is the backend for device streams: it marks the whole filesystem
+// on EventStream.Device, so a single mark covers any number of directories.
+// The paths in EventStream.Paths are relative to the root of the device, and
+// so are the paths of the events.
+//
+// This requires Linux 5.9 or newer, and CAP_SYS_ADMIN for the mark as well
+// as CAP_DAC_READ_SEARCH to resolve the directory handles in the events.
+type fanotify struct {
+	fdReader
+
+	mountPoint string   // where the root of the device is mounted
+	mountFD    int      // open directory on the device, for open_by_handle_at
+	roots      []string // absolute paths of EventStream.Paths
+
+	// Only accessed by the reader after start.
+	dirs map[string]string // directory paths by file handle
+}
+
+func newFanotify(es *EventStream) *fanotify {
+	return &fanotify{
+		fdReader: newFDReader(es),
+		mountFD:  -1,
+		dirs:     make(map[string]string),
+	}
+}
+
+func (f *fanotify) start() error {
+	mounts, err := readMountInfo()
+	if err != nil {
+		return fmt.Errorf("fsevents: %w", err)
+	}
+	// Device holds the st_dev as returned by DeviceForPath.
+	f.mountPoint, err = deviceMountPoint(mounts, uint64(uint32(f.es.Device)))
+	if err != nil {
+		return err
+	}
+
+	f.roots = f.roots[:0]
+	for _, p := range f.es.Paths {
+		f.roots = append(f.roots, filepath.Join(f.mountPoint, p))
+	}
+	if len(f.roots) == 0 {
+		f.roots = append(f.roots, f.mountPoint)
+	}
+
+	fd, err := unix.FanotifyInit(unix.FAN_CLASS_NOTIF|unix.FAN_CLOEXEC|unix.FAN_NONBLOCK|unix.FAN_REPORT_DFID_NAME,
+		unix.O_RDONLY|unix.O_LARGEFILE)
+	if err != nil {
+		return newPathError("fanotify_init", f.mountPoint, err)
+	}
+	if err := f.open(fd); err != nil {
+		return err
+	}
+	if err := unix.FanotifyMark(fd, unix.FAN_MARK_ADD|unix.FAN_MARK_FILESYSTEM, fanotifyMask, unix.AT_FDCWD, f.mountPoint); err != nil {
+		f.close()
+		return newPathError("fanotify_mark", f.mountPoint, err)
+	}
+	f.mountFD, err = unix.Open(f.mountPoint, unix.O_RDONLY|unix.O_DIRECTORY|unix.O_CLOEXEC, 0)
+	if err != nil {
+		f.close()
+		return newPathError("open", f.mountPoint, err)
+	}
+
+	go f.run(make([]byte, 64*1024), f.convert, func() {})
+	return nil
+}
+
+func (f *fanotify) stop() {
+	f.fdReader.stop()
+	unix.Close(f.mountFD)
+}
+
+func (f *fanotify) describe() Description {
+	return Description{
+		Text: fmt.Sprintf("fanotify fd %d: device = %d, mount point = %q, paths = %q, latency = %s, flags = %#x",
+			f.fd, f.es.Device, f.mountPoint, f.roots, f.es.Latency, uint32(f.es.Flags)),
+		Paths: append([]string(nil), f.roots...),
+	}
+}
+
+// convert translates a buffer of fanotify events into Events.
+func (f *fanotify) convert(buf []byte) []Event {
+	var (
+		events []Event
+		self   = int32(os.Getpid())
+	)
+	for off := 0; off+unix.FAN_EVENT_METADATA_LEN <= len(buf); {
+		meta := (*unix.FanotifyEventMetadata)(unsafe.Pointer(&buf[off]))
+		if meta.Event_len < unix.FAN_EVENT_METADATA_LEN || off+int(meta.Event_len) > len(buf) {
+			break
+		}
+		info := buf[off+int(meta.Metadata_len) : off+int(meta.Event_len)]
+		off += int(meta.Event_len)
+
+		if meta.Fd >= 0 {
+			unix.Close(int(meta.Fd))
+		}
+		if meta.Mask&unix.FAN_Q_OVERFLOW != 0 {
+			for _, r := range f.roots {
+				events = append(events, Event{Path: f.relative(r), Flags: MustScanSubDirs | KernelDropped})
+			}
+			continue
+		}
+		if f.es.Flags&IgnoreSelf != 0 && meta.Pid == self {
+			continue
+		}
+
+		path, ok := f.path(info)
+		if !ok || !f.watched(path) {
+			continue
+		}
+		converted := f.convertOne(meta.Mask, path)
+		if f.es.Flags&MarkSelf != 0 && meta.Pid == self && len(converted) > 0 {
+			converted[0].Flags |= OwnEvent
+		}
+		events = append(events, converted...)
+	}
+	return events
+}
+
+func (f *fanotify) convertOne(mask uint64, path string) []Event {
+	var flags EventFlags
+	if mask&unix.FAN_CREATE != 0 {
+		flags |= ItemCreated
+	}
+	if mask&unix.FAN_DELETE != 0 {
+		flags |= ItemRemoved
+	}
+	if mask&(unix.FAN_MOVED_FROM|unix.FAN_MOVED_TO) != 0 {
+		flags |= ItemRenamed
+	}
+	if mask&unix.FAN_MODIFY != 0 {
+		flags |= ItemModified
+	}
+	if mask&unix.FAN_ATTRIB != 0 {
+		flags |= ItemInodeMetaMod
+	}
+	if flags == 0 {
+		return nil
+	}
+
+	if mask&unix.FAN_ONDIR != 0 {
+		flags |= ItemIsDir
+		// The cached paths of the directories below may be stale.
+		if mask&(unix.FAN_DELETE|unix.FAN_MOVED_FROM) != 0 {
+			f.dirs = make(map[string]string)
+		}
+	} else if fi, err := os.Lstat(path); err == nil && fi.Mode()&os.ModeSymlink != 0 {
+		flags |= ItemIsSymlink
+	} else {
+		flags |= ItemIsFile
+	}
+
+	ev := Event{Path: f.relative(path), Flags: flags}
+	// The item is no longer at the path once it's removed or moved away.
+	if mask&(unix.FAN_DELETE|unix.FAN_MOVED_FROM) == 0 {
+		ino, link := statInode(path)
+		ev.Inode, ev.Flags = ino, ev.Flags|link
+	}
+	events := []Event{ev}
+	if f.es.Flags&WatchRoot != 0 && flags&(ItemRemoved|ItemRenamed) != 0 {
+		for _, r := range f.roots {
+			if r == path {
+				events = append(events, Event{Path: f.relative(path), Flags: RootChanged})
+			}
+		}
+	}
+	return events
+}
+
+// path returns the path of the entry in the info records of an event.
+func (f *fanotify) path(info []byte) (string, bool) {
+	for len(info) >= 4 {
+		var (
+			typ = info[0]
+			l   = int(*(*uint16)(unsafe.Pointer(&info[2])))
+		)
+		if l < 4 || l > len(info) {
+			return "", false
+		}
+		rec := info[:l]
+		info = info[l:]
+		if typ != unix.FAN_EVENT_INFO_TYPE_DFID_NAME && typ != unix.FAN_EVENT_INFO_TYPE_DFID {
+			continue
+		}
+
+		// struct fanotify_event_info_fid: header, fsid, struct file_handle
+		// and, for DFID_NAME, the name of the entry.
+		const handleOff = 4 + 8
+		if len(rec) < handleOff+8 {
+			return "", false
+		}
+		var (
+			size = int(*(*uint32)(unsafe.Pointer(&rec[handleOff])))
+			htyp = *(*int32)(unsafe.Pointer(&rec[handleOff+4]))
+			hend = handleOff + 8 + size
+		)
+		if hend > len(rec) {
+			return "", false
+		}
+		dir, ok := f.dir(htyp, rec[handleOff+8:hend])
+		if !ok {
+			return "", false
+		}
+		name := string(rec[hend:])
+		if i := strings.IndexByte(name, 0); i > -1 {
+			name = name[:i]
+		}
+		if name == "" || name == "." {
+			return dir, true
+		}
+		return filepath.Join(dir, name), true
+	}
+	return "", false
+}
+
+// dir resolves a directory file handle to its current path.
+func (f *fanotify) dir(typ int32, handle []byte) (string, bool) {
+	key := strconv.Itoa(int(typ)) + ":" + string(handle)
+	if p, ok := f.dirs[key]; ok {
+		return p, true
+	}
+
+	fd, err := unix.OpenByHandleAt(f.mountFD, unix.NewFileHandle(typ, handle), unix.O_PATH|unix.O_CLOEXEC)
+	if err != nil {
+		// The directory is already gone.
+		return "", false
+	}
+	defer unix.Close(fd)
+
+	p, err := os.Readlink("/proc/self/fd/" + strconv.Itoa(fd))
+	if err != nil {
+		return "", false
+	}
+	p = strings.TrimSuffix(p, " (deleted)")
+	f.dirs[key] = p
+	return p, true
+}
+
+// watched reports if path is one of the roots or below one.
+func (f *fanotify) watched(path string) bool {
+	for _, r := range f.roots {
+		if path == r || strings.HasPrefix(path, strings.TrimSuffix(r, "/")+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// relative returns path relative to the root of the device, the way FSEvents
+// reports paths for device streams.
+func (f *fanotify) relative(path string) string {
+	rel, err := filepath.Rel(f.mountPoint, path)
+	if err != nil || rel == "." {
+		return ""
+	}
+	return rel
+}
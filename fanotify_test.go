@@ -0,0 +1,196 @@
+//go:build linux
+
+package fsevents
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestFanotify(t *testing.T) {
+	tmp := t.TempDir()
+	other := t.TempDir()
+
+	dev, err := DeviceForPath(tmp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mounts, err := readMountInfo()
+	if err != nil {
+		t.Fatal(err)
+	}
+	mnt, err := deviceMountPoint(mounts, uint64(uint32(dev)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rel, err := filepath.Rel(mnt, tmp)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	es := &EventStream{
+		Paths:  []string{rel},
+		Device: dev,
+		Flags:  FileEvents,
+		Events: make(chan []Event, 16),
+	}
+	if err := es.Start(); err != nil {
+		if errors.Is(err, unix.EPERM) || errors.Is(err, unix.EINVAL) || errors.Is(err, unix.ENOSYS) {
+			t.Skipf("fanotify not available: %s", err)
+		}
+		t.Fatal(err)
+	}
+	defer es.Stop()
+
+	touch(t, other, "file")
+	mkdir(t, tmp, "dir")
+	touch(t, tmp, "dir", "file")
+	rmAll(t, tmp, "dir")
+
+	es.Flush(true)
+
+	var have Events
+	for len(es.Events) > 0 {
+		have = append(have, <-es.Events...)
+	}
+	want := Events{
+		{Path: filepath.Join(rel, "dir"), Flags: ItemCreated | ItemIsDir},
+		{Path: filepath.Join(rel, "dir", "file"), Flags: ItemCreated | ItemIsFile},
+		{Path: filepath.Join(rel, "dir", "file"), Flags: ItemRemoved | ItemIsFile},
+		{Path: filepath.Join(rel, "dir"), Flags: ItemRemoved | ItemIsDir},
+	}
+	if have.String() != want.String() {
+		t.Errorf("\nhave:\n%s\nwant:\n%s", indent(have), indent(want))
+	}
+}
+
+func TestFanotifyAbsolutePaths(t *testing.T) {
+	tmp, err := filepath.EvalSymlinks(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	dev, err := DeviceForPath(tmp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mnt, err := DeviceMountPoint(DeviceID(uint32(dev)))
+	if err != nil {
+		t.Skipf("no mount for the root of %s: %s", tmp, err)
+	}
+	rel, err := filepath.Rel(mnt, tmp)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	es := &EventStream{
+		Paths:         []string{rel},
+		Device:        dev,
+		Flags:         FileEvents,
+		AbsolutePaths: true,
+		Events:        make(chan []Event, 16),
+	}
+	if err := es.Start(); err != nil {
+		if errors.Is(err, unix.EPERM) || errors.Is(err, unix.EINVAL) || errors.Is(err, unix.ENOSYS) {
+			t.Skipf("fanotify not available: %s", err)
+		}
+		t.Fatal(err)
+	}
+	defer es.Stop()
+
+	touch(t, tmp, "file")
+	es.Flush(true)
+
+	have := Events(<-es.Events)
+	want := Events{{Path: filepath.Join(tmp, "file"), Flags: ItemCreated | ItemIsFile}}
+	if have.String() != want.String() {
+		t.Errorf("\nhave:\n%s\nwant:\n%s", indent(have), indent(want))
+	}
+}
+
+func TestFanotifyMarkSelf(t *testing.T) {
+	tmp, err := filepath.EvalSymlinks(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	dev, err := DeviceForPath(tmp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mnt, err := DeviceMountPoint(DeviceID(uint32(dev)))
+	if err != nil {
+		t.Skipf("no mount for the root of %s: %s", tmp, err)
+	}
+	rel, err := filepath.Rel(mnt, tmp)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	es := &EventStream{
+		Paths:  []string{rel},
+		Device: dev,
+		Flags:  FileEvents | MarkSelf,
+		Events: make(chan []Event, 16),
+	}
+	if err := es.Start(); err != nil {
+		if errors.Is(err, unix.EPERM) || errors.Is(err, unix.EINVAL) || errors.Is(err, unix.ENOSYS) {
+			t.Skipf("fanotify not available: %s", err)
+		}
+		t.Fatal(err)
+	}
+	defer es.Stop()
+
+	touch(t, tmp, "file")
+	var st unix.Stat_t
+	if err := unix.Lstat(filepath.Join(tmp, "file"), &st); err != nil {
+		t.Fatal(err)
+	}
+	es.Flush(true)
+
+	have := <-es.Events
+	if len(have) != 1 || have[0].Flags != ItemCreated|ItemIsFile|OwnEvent || have[0].Inode != st.Ino {
+		t.Errorf("received %+v, want an own event with inode %d", have, st.Ino)
+	}
+}
+
+func TestFanotifyNoMount(t *testing.T) {
+	es := &EventStream{Paths: []string{"/"}, Device: int32(unix.Mkdev(4095, 255))}
+	if err := es.Start(); err == nil {
+		es.Stop()
+		t.Fatal("no error for a device that isn't mounted")
+	}
+}
+
+func TestFanotifyOverflow(t *testing.T) {
+	meta := unix.FanotifyEventMetadata{
+		Event_len:    unix.FAN_EVENT_METADATA_LEN,
+		Vers:         unix.FANOTIFY_METADATA_VERSION,
+		Metadata_len: unix.FAN_EVENT_METADATA_LEN,
+		Mask:         unix.FAN_Q_OVERFLOW,
+		Fd:           unix.FAN_NOFD,
+	}
+	buf := (*[unix.FAN_EVENT_METADATA_LEN]byte)(unsafe.Pointer(&meta))[:]
+
+	tests := []struct {
+		paths []string
+		roots []string
+		want  Events
+	}{
+		// The whole device is watched.
+		{nil, []string{"/mnt"}, Events{{Path: "", Flags: MustScanSubDirs | KernelDropped}}},
+		{[]string{"a", "b/c"}, []string{"/mnt/a", "/mnt/b/c"}, Events{
+			{Path: "a", Flags: MustScanSubDirs | KernelDropped},
+			{Path: "b/c", Flags: MustScanSubDirs | KernelDropped},
+		}},
+	}
+	for _, tt := range tests {
+		f := &fanotify{mountPoint: "/mnt", roots: tt.roots}
+		f.es = &EventStream{Paths: tt.paths}
+		if have := Events(f.convert(buf)); have.String() != tt.want.String() {
+			t.Errorf("%q:\nhave:\n%s\nwant:\n%s", tt.paths, indent(have), indent(tt.want))
+		}
+	}
+}
@@ -0,0 +1,266 @@
+package fsevents
+
+// Event represents a single file system notification.
+type Event struct {
+	// Path holds the path to the item that's changed. For streams
+	// with a Device, it's relative to the root of the device, unless
+	// EventStream.AbsolutePaths is set; DeviceMountPoint returns where
+	// the device is mounted.
+	Path string `json:"path"`
+
+	// Flags holds details what has happened.
+	Flags EventFlags `json:"flags"`
+
+	// ID holds the event ID.
+	//
+	// Each event ID comes from the most recent event being reported
+	// in the corresponding directory named in the EventStream.Paths field
+	// Event IDs all come from a single global source.
+	// They are guaranteed to always be increasing, usually in leaps
+	// and bounds, even across system reboots and moving drives from
+	// one machine to another. If you were to
+	// stop processing events from this stream after this event
+	// and resume processing them later from a newly-created
+	// EventStream, this is the value you would pass for the
+	// EventStream.EventID along with Resume=true.
+	ID uint64 `json:"id"`
+
+	// Inode holds the inode number of the item, which identifies it
+	// across renames. On macOS it's reported by FSEvents if the stream
+	// has UseExtendedData set, and zero otherwise; on Linux it's read with
+	// statx when the event is received, so it's zero for items that no
+	// longer exist by then, such as removed ones. It's also zero for the
+	// events about the stream itself, such as HistoryDone.
+	Inode uint64 `json:"inode,omitempty"`
+}
+
+// The values of the flags below match the kFSEventStream* constants from
+// FSEvents.h, so events and create flags have the same meaning everywhere.
+// They're defined without cgo so that code which only stores or inspects
+// events builds on any platform; wrap.go checks them on macOS.
+
+// CreateFlags specifies what events will be seen in an event stream.
+type CreateFlags uint32
+
+const (
+	// NoDefer sends events on the leading edge (for interactive applications).
+	// By default events are delivered after latency seconds (for background tasks).
+	//
+	// Affects the meaning of the EventStream.Latency parameter. If you specify
+	// this flag and more than latency seconds have elapsed since
+	// the last event, your app will receive the event immediately.
+	// The delivery of the event resets the latency timer and any
+	// further events will be delivered after latency seconds have
+	// elapsed. This flag is useful for apps that are interactive
+	// and want to react immediately to changes but avoid getting
+	// swamped by notifications when changes are occurring in rapid
+	// succession. If you do not specify this flag, then when an
+	// event occurs after a period of no events, the latency timer
+	// is started. Any events that occur during the next latency
+	// seconds will be delivered as one group (including that first
+	// event). The delivery of the group of events resets the
+	// latency timer and any further events will be delivered after
+	// latency seconds. This is the default behavior and is more
+	// appropriate for background, daemon or batch processing apps.
+	NoDefer = CreateFlags(0x00000002)
+
+	// WatchRoot requests notifications of changes along the path to
+	// the path(s) you're watching. For example, with this flag, if
+	// you watch "/foo/bar" and it is renamed to "/foo/bar.old", you
+	// would receive a RootChanged event. The same is true if the
+	// directory "/foo" were renamed. The event you receive is a
+	// special event: the path for the event is the original path
+	// you specified, the flag RootChanged is set and event ID is
+	// zero. RootChanged events are useful to indicate that you
+	// should rescan a particular hierarchy because it changed
+	// completely (as opposed to the things inside of it changing).
+	// If you want to track the current location of a directory, it
+	// is best to open the directory before creating the stream so
+	// that you have a file descriptor for it and can issue an
+	// F_GETPATH fcntl() to find the current path.
+	WatchRoot = CreateFlags(0x00000004)
+
+	// IgnoreSelf doesn't send events triggered by the current process (macOS 10.6+).
+	//
+	// Don't send events that were triggered by the current process.
+	// This is useful for reducing the volume of events that are
+	// sent. It is only useful if your process might modify the file
+	// system hierarchy beneath the path(s) being monitored. Note:
+	// this has no effect on historical events, i.e., those
+	// delivered before the HistoryDone sentinel event.
+	IgnoreSelf = CreateFlags(0x00000008)
+
+	// FileEvents sends events about individual files, generating significantly
+	// more events (macOS 10.7+) than directory level notifications.
+	FileEvents = CreateFlags(0x00000010)
+
+	// MarkSelf sets OwnEvent on the events triggered by the current
+	// process (macOS 10.9+). On Linux, only device streams, which use
+	// fanotify, can tell.
+	MarkSelf = CreateFlags(0x00000020)
+
+	// UseExtendedData requests the inode of the items along with their
+	// paths, to fill in Event.Inode on macOS (macOS 10.13+). Without it,
+	// Event.Inode is zero with the native backend on macOS. The other
+	// backends always fill it in.
+	UseExtendedData = CreateFlags(0x00000040)
+
+	// FullHistory delivers all the recorded events for the paths when
+	// resuming, rather than only the most recent event for each path
+	// (macOS 10.15+). The journal used on Linux always keeps all of them.
+	FullHistory = CreateFlags(0x00000080)
+)
+
+// EventFlags passed to the FSEventStreamCallback function.
+// These correspond directly to the flags as described here:
+// https://developer.apple.com/documentation/coreservices/1455361-fseventstreameventflags
+type EventFlags uint32
+
+const (
+	// MustScanSubDirs indicates that events were coalesced hierarchically.
+	//
+	// Your application must rescan not just the directory given in
+	// the event, but all its children, recursively. This can happen
+	// if there was a problem whereby events were coalesced
+	// hierarchically. For example, an event in /Users/jsmith/Music
+	// and an event in /Users/jsmith/Pictures might be coalesced
+	// into an event with this flag set and path=/Users/jsmith. If
+	// this flag is set you may be able to get an idea of whether
+	// the bottleneck happened in the kernel (less likely) or in
+	// your client (more likely) by checking for the presence of the
+	// informational flags UserDropped or KernelDropped.
+	MustScanSubDirs EventFlags = EventFlags(0x00000001)
+
+	// KernelDropped or UserDropped may be set in addition
+	// to the MustScanSubDirs flag to indicate that a problem
+	// occurred in buffering the events (the particular flag set
+	// indicates where the problem occurred) and that the client
+	// must do a full scan of any directories (and their
+	// subdirectories, recursively) being monitored by this stream.
+	// If you asked to monitor multiple paths with this stream then
+	// you will be notified about all of them. Your code need only
+	// check for the MustScanSubDirs flag; these flags (if present)
+	// only provide information to help you diagnose the problem.
+	KernelDropped = EventFlags(0x00000004)
+
+	// UserDropped is related to UserDropped above.
+	UserDropped = EventFlags(0x00000002)
+
+	// EventIDsWrapped indicates the 64-bit event ID counter wrapped around.
+	//
+	// If EventIdsWrapped is set, it means
+	// the 64-bit event ID counter wrapped around. As a result,
+	// previously-issued event ID's are no longer valid
+	// for the EventID field when using EventStream.Resume.
+	EventIDsWrapped = EventFlags(0x00000008)
+
+	// HistoryDone is a sentinel event when retrieving events with EventStream.Resume.
+	//
+	// Denotes a sentinel event sent to mark the end of the
+	// "historical" events sent as a result of specifying
+	// EventStream.Resume.
+	//
+	// After sending all the "historical" events that occurred before now,
+	// an event will be sent with the HistoryDone flag set. The client
+	// should ignore the path supplied in that event.
+	HistoryDone = EventFlags(0x00000010)
+
+	// RootChanged indicates a change to a directory along the path being watched.
+	//
+	// Denotes a special event sent when there is a change to one of
+	// the directories along the path to one of the directories you
+	// asked to watch. When this flag is set, the event ID is zero
+	// and the path corresponds to one of the paths you asked to
+	// watch (specifically, the one that changed). The path may no
+	// longer exist because it or one of its parents was deleted or
+	// renamed. Events with this flag set will only be sent if you
+	// passed the flag WatchRoot when you created the stream.
+	RootChanged = EventFlags(0x00000020)
+
+	// Mount for a volume mounted underneath the path being monitored.
+	//
+	// Denotes a special event sent when a volume is mounted
+	// underneath one of the paths being monitored. The path in the
+	// event is the path to the newly-mounted volume. You will
+	// receive one of these notifications for every volume mount
+	// event inside the kernel (independent of DiskArbitration).
+	// Beware that a newly-mounted volume could contain an
+	// arbitrarily large directory hierarchy. Avoid pitfalls like
+	// triggering a recursive scan of a non-local filesystem, which
+	// you can detect by checking for the absence of the MNT_LOCAL
+	// flag in the f_flags returned by statfs(). Also be aware of
+	// the MNT_DONTBROWSE flag that is set for volumes which should
+	// not be displayed by user interface elements.
+	Mount = EventFlags(0x00000040)
+
+	// Unmount event occurs after a volume is unmounted.
+	//
+	// Denotes a special event sent when a volume is unmounted
+	// underneath one of the paths being monitored. The path in the
+	// event is the path to the directory from which the volume was
+	// unmounted. You will receive one of these notifications for
+	// every volume unmount event inside the kernel. This is not a
+	// substitute for the notifications provided by the
+	// DiskArbitration framework; you only get notified after the
+	// unmount has occurred. Beware that unmounting a volume could
+	// uncover an arbitrarily large directory hierarchy, although
+	// macOS never does that.
+	Unmount = EventFlags(0x00000080)
+
+	// The following flags are only set when using FileEvents.
+
+	// ItemCreated indicates that a file or directory has been created.
+	ItemCreated = EventFlags(0x00000100)
+
+	// ItemRemoved indicates that a file or directory has been removed.
+	ItemRemoved = EventFlags(0x00000200)
+
+	// ItemInodeMetaMod indicates that a file or directory's metadata has has been modified.
+	ItemInodeMetaMod = EventFlags(0x00000400)
+
+	// ItemRenamed indicates that a file or directory has been renamed.
+	// The old and the new path are reported as separate events;
+	// PairRenames pairs them.
+	ItemRenamed = EventFlags(0x00000800)
+
+	// ItemModified indicates that a file has been modified.
+	ItemModified = EventFlags(0x00001000)
+
+	// ItemFinderInfoMod indicates the the item's Finder information has been
+	// modified.
+	// TODO the above is just a guess.
+	ItemFinderInfoMod = EventFlags(0x00002000)
+
+	// ItemChangeOwner indicates that the file has changed ownership.
+	ItemChangeOwner = EventFlags(0x00004000)
+
+	// ItemXattrMod indicates that the files extended attributes have changed.
+	ItemXattrMod = EventFlags(0x00008000)
+
+	// ItemIsFile indicates that the item is a file.
+	ItemIsFile = EventFlags(0x00010000)
+
+	// ItemIsDir indicates that the item is a directory.
+	ItemIsDir = EventFlags(0x00020000)
+
+	// ItemIsSymlink indicates that the item is a symbolic link.
+	ItemIsSymlink = EventFlags(0x00040000)
+
+	// OwnEvent indicates that the event was triggered by the current
+	// process. It's only set with MarkSelf.
+	OwnEvent = EventFlags(0x00080000)
+
+	// ItemIsHardlink indicates that the item is a file with more than
+	// one link.
+	ItemIsHardlink = EventFlags(0x00100000)
+
+	// ItemIsLastHardlink indicates that the item was the last link of a
+	// file that had more than one. It's only reported by FSEvents: the
+	// inotify, fanotify and poll backends never set it.
+	ItemIsLastHardlink = EventFlags(0x00200000)
+
+	// ItemCloned indicates that the item is a clone, or was cloned
+	// (APFS). It's only reported by FSEvents: the inotify, fanotify and
+	// poll backends never set it.
+	ItemCloned = EventFlags(0x00400000)
+)
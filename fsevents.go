@@ -1,47 +1,31 @@
-//go:build darwin
+//go:build darwin || linux
 
 // Package fsevents provides file system notifications on macOS.
+//
+// On Linux the same EventStream API is provided on top of inotify, with
+// events reported using the FSEvents flags.
 package fsevents
 
 import (
+	"context"
+	"fmt"
+	"path/filepath"
 	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 )
 
-// Event represents a single file system notification.
-type Event struct {
-	// Path holds the path to the item that's changed, relative
-	// to its device's root.
-	// Use DeviceForPath to determine the absolute path that's
-	// being referred to.
-	Path string
-
-	// Flags holds details what has happened.
-	Flags EventFlags
-
-	// ID holds the event ID.
-	//
-	// Each event ID comes from the most recent event being reported
-	// in the corresponding directory named in the EventStream.Paths field
-	// Event IDs all come from a single global source.
-	// They are guaranteed to always be increasing, usually in leaps
-	// and bounds, even across system reboots and moving drives from
-	// one machine to another. If you were to
-	// stop processing events from this stream after this event
-	// and resume processing them later from a newly-created
-	// EventStream, this is the value you would pass for the
-	// EventStream.EventID along with Resume=true.
-	ID uint64
-}
-
 // DeviceForPath returns the device ID for the specified volume.
+//
+// On Linux device IDs are 64 bits wide and may be truncated; use
+// DeviceIDForPath for the full ID.
 func DeviceForPath(path string) (int32, error) {
 	stat := syscall.Stat_t{}
 	if err := syscall.Lstat(path, &stat); err != nil {
 		return 0, err
 	}
-	return stat.Dev, nil
+	return int32(stat.Dev), nil
 }
 
 // EventStream is the primary interface to FSEvents
@@ -52,17 +36,59 @@ func DeviceForPath(path string) (int32, error) {
 //	es.Start()
 //	es.Stop()
 //	...
+//
+// Stop closes Events once the stream has stopped, so consumers can range over
+// it. Stop, Flush and Restart may be called from any goroutine, including the
+// one receiving from Events. Stop waits until the events being delivered have
+// either been received or dropped, and does nothing if the stream isn't
+// started. Restart keeps Events open: consumers continue to receive events,
+// resuming from the last batch they received if Resume is supported.
 type EventStream struct {
-	stream       fsEventStreamRef
-	qref         fsDispatchQueueRef
+	// Accessed atomically, and first for alignment on 32-bit platforms.
+	latestID   uint64 // ID of the last event delivered
+	ackedID    uint64 // ID passed to Ack
+	generation uint64 // incremented by every start
+	failed     uint64 // generation in which the Handler failed
+	flags      uint32 // Flags, for the goroutines delivering events
+
+	paths atomic.Value // Paths, for the goroutines delivering events
+
+	mu           sync.Mutex // serializes Start, Stop and Restart
+	backend      backend
 	hasFinalizer bool
-	registryID   uintptr
-	uuid         string
+	gate         chan struct{}  // send waits until it's closed
+	queue        *overflowQueue // for the Overflow policy
+	handoff      *handoff       // set on the streams started by Reconfigure
+	drains       []*drain       // backends replaced by Reconfigure
+	running      *Run           // set by StartContext
+	closed       bool           // Events was closed by Stop
+	err          error          // why the stream stopped, returned by Err
+
+	// absPath makes the paths of events absolute with AbsolutePaths. It's
+	// set by start.
+	absPath func(rel string) (string, bool)
+
+	statsMu sync.Mutex
+	stats   Stats
 
 	// Events holds the channel on which events will be sent.
-	// It's initialized by EventStream.Start if nil.
+	// It's initialized by EventStream.Start if nil, and replaced by
+	// a new channel with the same capacity if it was closed by Stop.
+	// Nothing is sent on it if Handler or FlatEvents is set.
 	Events chan []Event
 
+	// FlatEvents, if not nil, receives the events one at a time instead
+	// of in batches on Events. Like Events, it's closed by Stop, and
+	// replaced by a new channel with the same capacity by Start.
+	FlatEvents chan Event
+
+	// Errors, if not nil, receives the errors that occur while the
+	// stream is running, such as directories that can't be watched or
+	// a Journal that can't be written. Like Events it must be received
+	// from, as the stream waits until an error has been received. It's
+	// not closed by Stop, so it may be shared by several streams.
+	Errors chan error
+
 	// Paths holds the set of paths to watch, each
 	// specifying the root of a filesystem hierarchy to be
 	// watched for modifications.
@@ -79,9 +105,23 @@ type EventStream struct {
 	//
 	// NOTE: this is updated asynchronously by the
 	// watcher and should not be accessed while
-	// the stream has been started.
+	// the stream has been started. Use LatestEventID
+	// and AckedEventID instead.
 	EventID uint64
 
+	// Overflow selects what happens when the consumer doesn't keep up
+	// with the events. The default, BlockOverflow, waits until the
+	// consumer receives them.
+	Overflow Overflow
+
+	// OverflowBuffer holds how many batches are buffered with
+	// TimeoutOverflow and DropOverflow. It defaults to 16.
+	OverflowBuffer int
+
+	// OverflowTimeout holds how long TimeoutOverflow waits for the
+	// consumer when the buffer is full. It defaults to one second.
+	OverflowTimeout time.Duration
+
 	// Latency holds the number of seconds the service should wait after hearing
 	// about an event from the kernel before passing it along to the
 	// client via its callback. Specifying a larger value may result
@@ -97,87 +137,542 @@ type EventStream struct {
 	// structure of a file on that device or the f_fsid[0] field of
 	// a statfs structure.
 	Device int32
-}
 
-// eventStreamRegistry is a lookup table for EventStream references passed to
-// cgo. In Go 1.6+ passing a Go pointer to a Go pointer to cgo is not allowed.
-// To get around this issue, we pass only an integer.
-type eventStreamRegistry struct {
-	sync.Mutex
-	m      map[uintptr]*EventStream
-	lastID uintptr
-}
+	// AbsolutePaths makes the paths of the events of a device stream
+	// absolute, by joining them with where the device is mounted, as
+	// found when the stream is started. On Linux, if the root of the
+	// filesystem isn't mounted, such as in containers, the paths are
+	// resolved through the bind mounts of its directories instead, and
+	// the paths that aren't mounted anywhere are left relative.
+	AbsolutePaths bool
+
+	// Backend selects the mechanism used to watch for changes. The
+	// default is the native one for the platform.
+	Backend Backend
+
+	// PollInterval holds how often PollBackend scans the paths for
+	// changes. It defaults to one second.
+	PollInterval time.Duration
 
-var registry = eventStreamRegistry{m: map[uintptr]*EventStream{}}
+	// Journal records the events on disk, so that EventID and Resume work
+	// with the backends that don't get them from FSEvents. It's not used
+	// by the native backend on macOS.
+	Journal *Journal
 
-func (r *eventStreamRegistry) Add(e *EventStream) uintptr {
-	r.Lock()
-	defer r.Unlock()
+	// Logger, if not nil, receives the diagnostics of the stream instead
+	// of the Logger set with SetLogger.
+	Logger Logger
 
-	r.lastID++
-	r.m[r.lastID] = e
-	return r.lastID
+	// Handler, if not nil, receives the batches of events instead of
+	// Events. OnHandlerError selects what happens when it returns an
+	// error.
+	Handler        Handler
+	OnHandlerError HandlerError
 }
 
-func (r *eventStreamRegistry) Get(i uintptr) *EventStream {
-	r.Lock()
-	defer r.Unlock()
+// Backend selects the mechanism an EventStream uses to watch for changes.
+type Backend int
 
-	return r.m[i]
+const (
+	// NativeBackend uses the notification API of the platform: FSEvents
+	// on macOS, and inotify, or fanotify for device streams, on Linux.
+	NativeBackend Backend = iota
+
+	// PollBackend periodically walks the paths and compares the results
+	// with the previous walk. It works on any filesystem, including
+	// network mounts and overlay filesystems where the native API is
+	// unreliable, but it can't detect renames and misses changes that are
+	// undone within a single poll interval. Device streams are not
+	// supported.
+	PollBackend
+)
+
+func (b Backend) String() string {
+	switch b {
+	case NativeBackend:
+		return "NativeBackend"
+	case PollBackend:
+		return "PollBackend"
+	default:
+		return fmt.Sprintf("Backend(%d)", int(b))
+	}
 }
 
-func (r *eventStreamRegistry) Delete(i uintptr) {
-	r.Lock()
-	defer r.Unlock()
+// backend is the platform mechanism that feeds an EventStream.
+type backend interface {
+	// start begins watching the stream's paths.
+	start() error
 
-	delete(r.m, i)
+	// flush delivers events that have occurred but haven't been
+	// delivered yet, blocking until done if sync is true.
+	flush(sync bool)
+
+	// stop stops watching and releases all resources.
+	stop()
+
+	// drain stops reading events, delivers the ones it already read, and
+	// returns once it's done or stop was called. stop is still called
+	// after it, to release the resources.
+	drain()
+
+	// describe describes the running backend.
+	describe() Description
 }
 
 // Start listening to an event stream. This creates es.Events if it's not already
 // a valid channel.
+//
+// The errors returned can be matched with errors.Is: ErrAlreadyStarted if the
+// stream is started, ErrTooManyPaths, and for a *PathError, ErrPathNotFound
+// or ErrPermission. Path errors are only returned on Linux and by
+// PollBackend, as FSEvents doesn't check the paths.
 func (es *EventStream) Start() error {
+	es.mu.Lock()
+	defer es.mu.Unlock()
+	return es.start()
+}
+
+// StartContext starts listening to the event stream like Start, until ctx is
+// done. The stream is then stopped like by Stop, which closes es.Events.
+//
+// The returned Run reports when the stream stopped and why. Calling Stop also
+// finishes the Run, while Restart keeps it running with the same es.Events.
+func (es *EventStream) StartContext(ctx context.Context) (*Run, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	es.mu.Lock()
+	if err := es.start(); err != nil {
+		es.mu.Unlock()
+		return nil, err
+	}
+	r := newRun()
+	es.running = r
+	es.mu.Unlock()
+
+	go func() {
+		select {
+		case <-ctx.Done():
+			es.mu.Lock()
+			if es.running == r {
+				es.stop()
+				es.close(ctx.Err())
+			}
+			es.mu.Unlock()
+		case <-r.done:
+		}
+	}()
+	return r, nil
+}
+
+func (es *EventStream) start() error {
+	if es.backend != nil {
+		return ErrAlreadyStarted
+	}
+	atomic.StoreUint64(&es.latestID, es.EventID)
+	atomic.AddUint64(&es.generation, 1)
+	es.paths.Store(es.Paths)
+	atomic.StoreUint32(&es.flags, uint32(es.Flags))
 	if es.Events == nil {
 		es.Events = make(chan []Event)
+	} else if es.closed {
+		// Replace the channels closed by Stop with similar ones.
+		es.Events = make(chan []Event, cap(es.Events))
+		if es.FlatEvents != nil {
+			es.FlatEvents = make(chan Event, cap(es.FlatEvents))
+		}
+		es.closed = false
 	}
+	es.err = nil
 
-	// register eventstream in the local registry for later lookup
-	// in C callback
-	cbInfo := registry.Add(es)
-	es.registryID = cbInfo
-	es.uuid = GetDeviceUUID(es.Device)
-	err := es.start(es.Paths, cbInfo)
+	var (
+		b   backend
+		err error
+	)
+	switch es.Backend {
+	case NativeBackend:
+		b, err = newNativeBackend(es)
+	case PollBackend:
+		b, err = newPoller(es)
+	default:
+		err = fmt.Errorf("fsevents: unknown backend: %s", es.Backend)
+	}
 	if err != nil {
-		// Remove eventstream from the registry
-		registry.Delete(es.registryID)
-		es.registryID = 0
+		return err
 	}
-	return err
+	es.absPath = nil
+	if es.AbsolutePaths && es.Device != 0 {
+		if es.absPath, err = deviceAbsPath(DeviceID(uint32(es.Device))); err != nil {
+			return err
+		}
+	}
+	if es.Journal != nil && (es.Backend != NativeBackend || !nativeHistory) {
+		if es.Journal.closed() {
+			return errJournalClosed
+		}
+		b = newJournalBackend(es, b)
+	}
+
+	switch es.Overflow {
+	case BlockOverflow:
+	case TimeoutOverflow, DropOverflow:
+		es.queue = newOverflowQueue(es.Overflow, es.OverflowBuffer, es.OverflowTimeout, es.Paths)
+		es.queue.log = es.logger()
+		es.queue.dropped = es.dropped
+		es.queue.start(es.emit)
+	default:
+		return fmt.Errorf("fsevents: unknown overflow policy: %s", es.Overflow)
+	}
+
+	// EventID is updated by the backend once it's started.
+	es.logger().Debug("fsevents: starting", "event_id", es.EventID, "resume", es.Resume, "backend", es.Backend.String())
+	if err := b.start(); err != nil {
+		es.stopQueue()
+		return err
+	}
+	es.backend = b
+	return nil
 }
 
 // Flush flushes events that have occurred but haven't been delivered.
 // If sync is true, it will block until all the events have been delivered,
 // otherwise it will return immediately.
 func (es *EventStream) Flush(sync bool) {
-	flush(es.stream, sync)
+	// The backend may be stopped while flushing, which ends the flush.
+	es.mu.Lock()
+	b, q := es.backend, es.queue
+	es.mu.Unlock()
+
+	if b != nil {
+		b.flush(sync)
+	}
+	if q != nil && sync {
+		q.wait()
+	}
 }
 
-// Stop stops listening to the event stream.
+// Stop stops listening to the event stream, and closes es.Events and
+// es.FlatEvents. It does nothing if the stream isn't started.
 func (es *EventStream) Stop() {
-	if es.stream != nil {
-		stop(es.stream, es.qref)
-		es.stream = nil
-		es.qref = nil
+	es.mu.Lock()
+	defer es.mu.Unlock()
+
+	if es.backend != nil {
+		es.stop()
+		es.close(nil)
+	}
+}
+
+// stop stops the backend, once nothing is being delivered anymore. es.mu must
+// be held.
+func (es *EventStream) stop() {
+	// The backends replaced by Reconfigure may still be delivering what
+	// they had pending.
+	for _, d := range es.drains {
+		d.stop()
+		<-d.done
+	}
+	es.drains = nil
+	if es.backend != nil {
+		es.backend.stop()
+		es.backend = nil
+		es.logger().Debug("fsevents: stopped", "event_id", es.LatestEventID())
+	}
+	es.stopQueue()
+}
+
+// stopQueue stops delivering the batches queued for the Overflow policy. es.mu
+// must be held.
+func (es *EventStream) stopQueue() {
+	if es.queue != nil {
+		es.queue.stop()
+		es.queue = nil
 	}
+}
 
-	// Remove eventstream from the registry
-	registry.Delete(es.registryID)
-	es.registryID = 0
+// close closes Events and FlatEvents after the stream was stopped, and
+// finishes the Run of StartContext with err. es.mu must be held.
+func (es *EventStream) close(err error) {
+	close(es.Events)
+	if es.FlatEvents != nil {
+		close(es.FlatEvents)
+	}
+	es.closed = true
+	es.err = err
+	if es.running != nil {
+		es.running.finish(err)
+		es.running = nil
+	}
 }
 
 // Restart restarts the event listener. This
 // can be used to change the current watch flags.
+//
+// Events stays open, unless the stream can't be started again. The new stream
+// resumes from the ID passed to Ack if it was called, so the events that
+// weren't acknowledged are delivered again. Otherwise it resumes from
+// EventID, which is the ID of the last event that was received from Events.
 func (es *EventStream) Restart() error {
-	es.Stop()
+	es.mu.Lock()
+	defer es.mu.Unlock()
+
+	started := es.backend != nil
+	es.stop()
+	if id := atomic.LoadUint64(&es.ackedID); id != 0 {
+		es.EventID = id
+	}
 	es.Resume = true
-	return es.Start()
+	es.logger().Debug("fsevents: restarting", "event_id", es.EventID)
+	err := es.start()
+	if err != nil && started {
+		es.close(err)
+	}
+	return err
+}
+
+// LatestEventID returns the ID of the last event delivered on Events, or
+// EventID when the stream was started if none was. Unlike EventID, it's safe
+// to call while the stream is running, to checkpoint it for instance.
+func (es *EventStream) LatestEventID() uint64 {
+	return atomic.LoadUint64(&es.latestID)
+}
+
+// Ack acknowledges that the events up to id, usually the ID of the last event
+// of a batch, have been processed. Restart resumes from there, and it can be
+// read back with AckedEventID. It's safe to call from any goroutine.
+func (es *EventStream) Ack(id uint64) {
+	atomic.StoreUint64(&es.ackedID, id)
+}
+
+// AckedEventID returns the ID passed to Ack, or 0 if it wasn't called.
+func (es *EventStream) AckedEventID() uint64 {
+	return atomic.LoadUint64(&es.ackedID)
+}
+
+// Stats returns statistics about the batches the stream has delivered. It's
+// safe to call from any goroutine.
+func (es *EventStream) Stats() Stats {
+	es.statsMu.Lock()
+	s := es.stats
+	es.statsMu.Unlock()
+
+	if !s.LastBatch.IsZero() {
+		s.SinceLastBatch = time.Since(s.LastBatch)
+	}
+	return s
+}
+
+// Description describes a running stream, as returned by
+// EventStream.Describe.
+type Description struct {
+	// Text is a description of the stream for debugging. On macOS it's
+	// the one of FSEventStreamCopyDescription.
+	Text string
+
+	// Paths holds the paths being watched, as resolved by the backend.
+	Paths []string
+}
+
+// Describe returns a description of the stream, or a zero Description if it
+// isn't started.
+func (es *EventStream) Describe() Description {
+	es.mu.Lock()
+	defer es.mu.Unlock()
+
+	if es.backend == nil {
+		return Description{}
+	}
+	return es.backend.describe()
+}
+
+// deliver sends a batch of events on Events, or queues it for the Overflow
+// policy. The streams started by Reconfigure hand it to the stream they
+// replace it in. It returns false if done was closed before the events could be
+// delivered, in which case EventID isn't updated.
+func (es *EventStream) deliver(events []Event, done <-chan struct{}) bool {
+	if es.handoff != nil {
+		return es.handoff.deliver(events, done)
+	}
+	if es.queue != nil {
+		return es.queue.push(events, done)
+	}
+	return es.emit(events, done)
+}
+
+// emit hands a batch of events to the consumer: the Handler if it's set, or
+// Events. It returns false if done was closed before the events could be
+// delivered, or if the Handler failed and the stream is being stopped.
+func (es *EventStream) emit(events []Event, done <-chan struct{}) bool {
+	es.absolute(events)
+	if es.Handler != nil {
+		return es.handle(events, done)
+	}
+	if es.FlatEvents != nil {
+		return es.emitFlat(events, done)
+	}
+	blocked, ok := sendBatch(es.Events, events, done)
+	if ok {
+		es.delivered(events, blocked)
+	}
+	return ok
+}
+
+// absolute makes the paths of events absolute, if AbsolutePaths is set.
+func (es *EventStream) absolute(events []Event) {
+	if es.absPath == nil {
+		return
+	}
+	for i := range events {
+		events[i].Path, _ = es.absPath(events[i].Path)
+	}
+}
+
+// emitFlat sends a batch of events on FlatEvents, one at a time. If done is
+// closed before they're all sent, the events sent so far are recorded as
+// delivered.
+func (es *EventStream) emitFlat(events []Event, done <-chan struct{}) bool {
+	var blocked time.Duration
+	for i, e := range events {
+		d, ok := sendEvent(es.FlatEvents, e, done)
+		blocked += d
+		if !ok {
+			if i > 0 {
+				es.delivered(events[:i], blocked)
+			}
+			return false
+		}
+	}
+	es.delivered(events, blocked)
+	return true
+}
+
+// Err returns why the stream stopped: nil if it's running, wasn't started or
+// was stopped by Stop, and otherwise the error of the context of
+// StartContext, of a Restart that failed or of the Handler. It's meant to be
+// called once Events or FlatEvents is closed.
+func (es *EventStream) Err() error {
+	es.mu.Lock()
+	defer es.mu.Unlock()
+	return es.err
+}
+
+// delivered records that events were delivered on Events, after waiting
+// blocked for them to be received. It's only called by the goroutine
+// delivering events.
+func (es *EventStream) delivered(events []Event, blocked time.Duration) {
+	es.statsMu.Lock()
+	es.stats.add(events, blocked, time.Now())
+	es.statsMu.Unlock()
+
+	var last uint64
+	for _, e := range events {
+		if e.ID != 0 {
+			last = e.ID
+		}
+	}
+	if last != 0 {
+		es.EventID = last
+		atomic.StoreUint64(&es.latestID, last)
+	}
+}
+
+// dropped records that the Overflow policy dropped batches and events.
+func (es *EventStream) dropped(batches, events int) {
+	es.statsMu.Lock()
+	es.stats.DroppedBatches += uint64(batches)
+	es.stats.DroppedEvents += uint64(events)
+	es.statsMu.Unlock()
+}
+
+// logger returns the Logger of the stream, which adds its paths and device.
+func (es *EventStream) logger() Logger {
+	l := es.Logger
+	if l == nil {
+		l = packageLogger()
+	}
+	paths, ok := es.paths.Load().([]string)
+	if !ok {
+		paths = es.Paths
+	}
+	return withAttrs(l, "paths", paths, "device", es.Device)
+}
+
+// lastEventID is the source of the event IDs for the backends that don't get
+// them from FSEvents. Like with FSEvents all streams share a single source, so
+// IDs are always increasing, but they only persist across restarts of the
+// process with a Journal.
+var lastEventID uint64
+
+func nextEventID() uint64 {
+	return atomic.AddUint64(&lastEventID, 1)
+}
+
+// send delivers a batch of events from a backend that doesn't get event IDs
+// from FSEvents, and records it in the Journal. It returns false if done was
+// closed before the events could be delivered, in which case EventID isn't
+// updated.
+func (es *EventStream) send(events []Event, done <-chan struct{}) bool {
+	if es.gate != nil {
+		// The history from the journal is being delivered.
+		select {
+		case <-es.gate:
+		case <-done:
+			return false
+		}
+	}
+
+	if CreateFlags(atomic.LoadUint32(&es.flags))&FileEvents == 0 {
+		events = dirEvents(events)
+	}
+	for i := range events {
+		if events[i].ID == 0 && events[i].Flags&RootChanged == 0 {
+			events[i].ID = nextEventID()
+		}
+	}
+	if es.Journal != nil {
+		if err := es.Journal.record(es.Device, events); err != nil && !es.sendError(err, done) {
+			return false
+		}
+	}
+	return es.deliver(events, done)
+}
+
+// sendError logs err and reports it on Errors, if it's set. It returns false if
+// done was closed before the error could be delivered.
+func (es *EventStream) sendError(err error, done <-chan struct{}) bool {
+	es.logger().Error("fsevents: stream error", "err", err)
+	if es.Errors == nil {
+		return true
+	}
+	select {
+	case es.Errors <- err:
+		return true
+	case <-done:
+		return false
+	}
+}
+
+// dirEvents reduces file events to events for the directories they happened
+// in, which is what FSEvents reports without FileEvents. The event for a
+// directory has the ID of the last event it replaces.
+func dirEvents(events []Event) []Event {
+	var (
+		dirs = make([]Event, 0, len(events))
+		seen = make(map[string]int)
+	)
+	for _, e := range events {
+		if e.Flags&(ItemIsFile|ItemIsDir|ItemIsSymlink) != 0 {
+			e = Event{Path: filepath.Dir(e.Path), ID: e.ID}
+		}
+		if i, ok := seen[e.Path]; ok && e.Flags == 0 {
+			if e.ID > dirs[i].ID && dirs[i].Flags&RootChanged == 0 {
+				dirs[i].ID = e.ID
+			}
+			continue
+		}
+		seen[e.Path] = len(dirs)
+		dirs = append(dirs, e)
+	}
+	return dirs
 }
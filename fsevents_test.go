@@ -1,8 +1,10 @@
-//go:build darwin
+//go:build darwin || linux
 
 package fsevents
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"io/fs"
 	"os"
@@ -20,6 +22,10 @@ func TestScript(t *testing.T) {
 		}
 
 		if info.IsDir() {
+			// Fixtures for other tests, rather than scripts.
+			if path == filepath.Join("testdata", "mountinfo") {
+				return filepath.SkipDir
+			}
 			return nil
 		}
 
@@ -53,16 +59,12 @@ func TestBasicExample(t *testing.T) {
 	}
 	defer os.RemoveAll(path)
 
-	dev, err := DeviceForPath(path)
-	if err != nil {
-		t.Fatal(err)
-	}
-
 	es := &EventStream{
-		Paths:   []string{path},
-		Latency: 500 * time.Millisecond,
-		Device:  dev,
-		Flags:   FileEvents,
+		Paths:      []string{path},
+		Latency:    500 * time.Millisecond,
+		Device:     testDevice(t, path),
+		Flags:      FileEvents,
+		FlatEvents: make(chan Event),
 	}
 
 	err = es.Start()
@@ -72,13 +74,11 @@ func TestBasicExample(t *testing.T) {
 
 	wait := make(chan Event)
 	go func() {
-		for msg := range es.Events {
-			for _, event := range msg {
-				t.Logf("Event: %#v", event)
-				wait <- event
-				es.Stop()
-				return
-			}
+		for event := range es.FlatEvents {
+			t.Logf("Event: %#v", event)
+			wait <- event
+			es.Stop()
+			return
 		}
 	}()
 
@@ -93,113 +93,164 @@ func TestBasicExample(t *testing.T) {
 	}
 }
 
-func TestIssue48(t *testing.T) {
-	// FSEvents fails to start when watching >4096 paths
-	// This test validates that limit and checks that the error is propagated
-
-	path, err := os.MkdirTemp("", "fsmanyfiles")
+func TestStartContext(t *testing.T) {
+	path, err := filepath.EvalSymlinks(t.TempDir())
 	if err != nil {
 		t.Fatal(err)
 	}
-	path, err = filepath.EvalSymlinks(path)
-	if err != nil {
-		t.Fatal(err)
-	}
-	defer os.RemoveAll(path)
-
-	// TODO: using this value fails to start
-	// dev, err := DeviceForPath(path)
-	// if err != nil {
-	// 	t.Fatal(err)
-	// }
-
-	var filenames []string
-	for i := 0; i < 4096; i++ {
-		newFilename := filepath.Join(path, fmt.Sprint("test", i))
-		err = os.WriteFile(newFilename, []byte("test"), 0700)
-		if err != nil {
-			t.Fatal(err)
-		}
-		filenames = append(filenames, newFilename)
-	}
 
 	es := &EventStream{
-		Paths:   filenames,
-		Latency: 500 * time.Millisecond,
-		Device:  0, //dev,
-		Flags:   FileEvents,
+		Paths:  []string{path},
+		Device: testDevice(t, path),
+		Flags:  FileEvents | NoDefer,
 	}
 
-	err = es.Start()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	run, err := es.StartContext(ctx)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	wait := make(chan Event)
+	received := make(chan Event, 1)
+	ranged := make(chan struct{})
 	go func() {
+		defer close(ranged)
 		for msg := range es.Events {
-			for _, event := range msg {
-				t.Logf("Event: %#v", event)
-				wait <- event
-				es.Stop()
-				return
+			for _, e := range msg {
+				select {
+				case received <- e:
+				default:
+				}
 			}
 		}
 	}()
 
-	// write some new contents to test42 in the watchlist
-	err = os.WriteFile(filenames[42], []byte("special"), 0700)
-	if err != nil {
+	if err := os.WriteFile(filepath.Join(path, "file"), nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-received:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for event")
+	}
+
+	// Restart keeps the stream running.
+	if err := es.Restart(); err != nil {
 		t.Fatal(err)
 	}
+	if err := run.Err(); err != nil {
+		t.Fatalf("Err after Restart: %v", err)
+	}
 
-	// should be reported as expected
-	<-wait
+	cancel()
+	select {
+	case <-run.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the stream to stop")
+	}
+	if err := run.Wait(); !errors.Is(err, context.Canceled) {
+		t.Errorf("Wait returned %v, want %v", err, context.Canceled)
+	}
+	select {
+	case <-ranged:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Events wasn't closed")
+	}
 
-	/////
-	// create one more file that puts it over the edge
-	newFilename := filepath.Join(path, fmt.Sprint("test", 4096))
-	err = os.WriteFile(newFilename, []byte("test"), 0700)
+	// Stop finishes the run without an error.
+	run, err = es.StartContext(context.Background())
 	if err != nil {
 		t.Fatal(err)
 	}
-	filenames = append(filenames, newFilename)
-
-	// create an all-new instances to avoid problems
-	es2 := &EventStream{
-		Paths:   filenames,
-		Latency: 500 * time.Millisecond,
-		Device:  0, //dev,
-		Flags:   FileEvents,
+	es.Stop()
+	if err := run.Wait(); err != nil {
+		t.Errorf("Wait returned %v after Stop", err)
 	}
+	if _, ok := <-es.Events; ok {
+		t.Error("Events wasn't closed by Stop")
+	}
+	es.Stop()
 
-	err = es2.Start()
-	if err == nil {
-		es2.Stop()
-		t.Fatal("eventstream error was not detected on >4096 files in watchlist")
+	if _, err := es.StartContext(ctx); !errors.Is(err, context.Canceled) {
+		t.Errorf("StartContext with a done context returned %v", err)
 	}
 }
 
-func TestRegistry(t *testing.T) {
-	if registry.m == nil {
-		t.Fatal("registry not initialized at start")
+func TestStopConcurrent(t *testing.T) {
+	path, err := filepath.EvalSymlinks(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
 	}
 
-	es := &EventStream{}
-	i := registry.Add(es)
+	es := &EventStream{
+		Paths:  []string{path},
+		Device: testDevice(t, path),
+		Flags:  FileEvents | NoDefer,
+	}
+	if err := es.Start(); err != nil {
+		t.Fatal(err)
+	}
+	events := es.Events
 
-	if registry.Get(i) == nil {
-		t.Fatal("failed to retrieve es from registry")
+	// Nothing receives the events, so they're being delivered when the
+	// stream is stopped.
+	for i := 0; i < 10; i++ {
+		if err := os.WriteFile(filepath.Join(path, fmt.Sprint(i)), nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
 	}
+	go es.Flush(false)
 
-	if es != registry.Get(i) {
-		t.Errorf("eventstream did not match what was found in the registry")
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			es.Stop()
+		}()
+		go func() {
+			defer wg.Done()
+			es.Flush(true)
+		}()
 	}
+	wg.Wait()
+
+	for range events {
+		// Events may have been delivered before Stop, but the channel
+		// must be closed.
+	}
+	es.Stop()
 
-	registry.Delete(i)
-	if registry.Get(i) != nil {
-		t.Error("failed to delete registry")
+	// Restart after Stop starts a new channel.
+	if err := es.Restart(); err != nil {
+		t.Fatal(err)
+	}
+	if es.Events == events {
+		t.Error("Restart reused the closed channel")
+	}
+
+	// Restart while running keeps the channel open.
+	events = es.Events
+	ranged := make(chan struct{})
+	go func() {
+		defer close(ranged)
+		for range events {
+		}
+	}()
+	if err := es.Restart(); err != nil {
+		t.Fatal(err)
+	}
+	if es.Events != events {
+		t.Error("Restart replaced the channel")
+	}
+	select {
+	case <-ranged:
+		t.Fatal("Restart closed the channel")
+	case <-time.After(100 * time.Millisecond):
 	}
+	es.Stop()
+	<-ranged
 }
 
 func TestMany(t *testing.T) {
@@ -210,15 +261,10 @@ func TestMany(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	dev, err := DeviceForPath(path)
-	if err != nil {
-		t.Fatal(err)
-	}
-
 	es := &EventStream{
 		Paths:   []string{path},
 		Latency: 0,
-		Device:  dev,
+		Device:  testDevice(t, path),
 		Flags:   FileEvents | NoDefer,
 	}
 
@@ -307,6 +353,8 @@ func TestMany(t *testing.T) {
 	const fileExpectedFlags = ItemIsFile | ItemCreated | ItemModified | ItemRemoved
 	const dirExpectedFlags = ItemIsDir | ItemCreated | ItemRemoved
 
+	lock.Lock()
+	defer lock.Unlock()
 	for p, flags := range events {
 		if p == strings.TrimPrefix(path, "/") {
 			continue
@@ -1,3 +1,5 @@
 go 1.17
 
 module github.com/fsnotify/fsevents
+
+require golang.org/x/sys v0.13.0
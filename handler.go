@@ -0,0 +1,115 @@
+//go:build darwin || linux
+
+package fsevents
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+// Handler receives the batches of events of an EventStream directly, instead
+// of Events. HandleEvents is called from the goroutine delivering the events,
+// one batch at a time and in the same order as they'd be sent on Events, so it
+// holds up the stream until it returns. EventID and LatestEventID are only
+// updated once it succeeds.
+type Handler interface {
+	HandleEvents(events []Event) error
+}
+
+// HandlerFunc adapts a function to a Handler.
+type HandlerFunc func(events []Event) error
+
+// HandleEvents calls f(events).
+func (f HandlerFunc) HandleEvents(events []Event) error {
+	return f(events)
+}
+
+// HandlerError selects what an EventStream does when its Handler returns an
+// error.
+type HandlerError int
+
+const (
+	// StopOnError stops the stream like Stop. The Run of StartContext
+	// finishes with the error. EventID isn't updated, so the failed batch
+	// is delivered again by Restart if Resume is supported.
+	StopOnError HandlerError = iota
+
+	// RescanOnError calls the Handler again with a batch that has a
+	// MustScanSubDirs|UserDropped event for each of the paths instead of
+	// the failed one, like FSEvents does when it drops events, so that it
+	// rescans them. If that fails too, the stream is stopped like with
+	// StopOnError.
+	RescanOnError
+)
+
+func (h HandlerError) String() string {
+	switch h {
+	case StopOnError:
+		return "StopOnError"
+	case RescanOnError:
+		return "RescanOnError"
+	default:
+		return fmt.Sprintf("HandlerError(%d)", int(h))
+	}
+}
+
+// handle calls the Handler with a batch of events. It returns false if the
+// Handler failed, now or before, and the stream is being stopped.
+func (es *EventStream) handle(events []Event, done <-chan struct{}) bool {
+	gen := atomic.LoadUint64(&es.generation)
+	if atomic.LoadUint64(&es.failed) == gen {
+		return false
+	}
+
+	start := time.Now()
+	err := es.Handler.HandleEvents(events)
+	if err != nil {
+		es.logger().Error("fsevents: handler failed", "err", err)
+		if es.OnHandlerError == RescanOnError {
+			events = es.rescan(events)
+			if err = es.Handler.HandleEvents(events); err != nil {
+				es.logger().Error("fsevents: handler failed to rescan", "err", err)
+			}
+		}
+	}
+	if err == nil {
+		es.delivered(events, time.Since(start))
+		return true
+	}
+
+	// Stopping waits for the delivery to return, so it can't be done
+	// from here.
+	atomic.StoreUint64(&es.failed, gen)
+	go es.stopFailed(gen, fmt.Errorf("fsevents: handler: %w", err))
+	return false
+}
+
+// rescan returns the batch that replaces events that the Handler failed on.
+func (es *EventStream) rescan(events []Event) []Event {
+	var maxID uint64
+	for _, e := range events {
+		if e.ID > maxID {
+			maxID = e.ID
+		}
+	}
+	paths, _ := es.paths.Load().([]string)
+	batch := make([]Event, 0, len(paths))
+	for _, p := range paths {
+		batch = append(batch, Event{Path: p, Flags: MustScanSubDirs | UserDropped, ID: maxID})
+	}
+	es.absolute(batch)
+	return batch
+}
+
+// stopFailed stops the stream after its Handler failed with err, unless it
+// was already stopped or restarted meanwhile.
+func (es *EventStream) stopFailed(gen uint64, err error) {
+	es.mu.Lock()
+	defer es.mu.Unlock()
+
+	if es.backend != nil && atomic.LoadUint64(&es.generation) == gen {
+		es.stop()
+		es.close(err)
+	}
+}
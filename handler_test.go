@@ -0,0 +1,131 @@
+//go:build darwin || linux
+
+package fsevents
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// handlerStream returns a polling stream for tmp that delivers to h.
+func handlerStream(tmp string, h Handler) *EventStream {
+	return &EventStream{
+		Paths:        []string{tmp},
+		Flags:        FileEvents,
+		Backend:      PollBackend,
+		PollInterval: time.Hour,
+		Handler:      h,
+	}
+}
+
+func TestHandler(t *testing.T) {
+	tmp := tempDir(t)
+
+	var (
+		mu   sync.Mutex
+		have Events
+	)
+	es := handlerStream(tmp, HandlerFunc(func(events []Event) error {
+		mu.Lock()
+		have = append(have, events...)
+		mu.Unlock()
+		return nil
+	}))
+	if err := es.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer es.Stop()
+
+	touch(t, tmp, "a")
+	es.Flush(true)
+	touch(t, tmp, "b")
+	es.Flush(true)
+
+	mu.Lock()
+	defer mu.Unlock()
+	want := Events{
+		{Path: join(tmp, "a"), Flags: ItemCreated | ItemIsFile},
+		{Path: join(tmp, "b"), Flags: ItemCreated | ItemIsFile},
+	}
+	if have.String() != want.String() {
+		t.Fatalf("\nhave:\n%s\nwant:\n%s", indent(have), indent(want))
+	}
+	if id := es.LatestEventID(); id != have[1].ID || id <= have[0].ID {
+		t.Errorf("LatestEventID is %d; the events have IDs %d and %d", id, have[0].ID, have[1].ID)
+	}
+	if s := es.Stats(); s.Batches != 2 || s.Events != 2 {
+		t.Errorf("stats: %+v", s)
+	}
+}
+
+func TestHandlerStopOnError(t *testing.T) {
+	tmp := tempDir(t)
+
+	errHandler := errors.New("handler error")
+	var calls int
+	es := handlerStream(tmp, HandlerFunc(func(events []Event) error {
+		calls++
+		return errHandler
+	}))
+	run, err := es.StartContext(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer es.Stop()
+	before := es.LatestEventID()
+
+	touch(t, tmp, "a")
+	es.Flush(false)
+
+	if err := run.Wait(); !errors.Is(err, errHandler) {
+		t.Fatalf("Wait returned %v", err)
+	}
+	if _, ok := <-es.Events; ok {
+		t.Error("Events is open")
+	}
+	if calls != 1 {
+		t.Errorf("handler called %d times", calls)
+	}
+	if id := es.LatestEventID(); id != before {
+		t.Errorf("LatestEventID is %d, want %d", id, before)
+	}
+}
+
+func TestHandlerRescanOnError(t *testing.T) {
+	tmp := tempDir(t)
+
+	var batches [][]Event
+	es := handlerStream(tmp, HandlerFunc(func(events []Event) error {
+		batches = append(batches, events)
+		if len(batches) == 1 {
+			return errors.New("handler error")
+		}
+		return nil
+	}))
+	es.OnHandlerError = RescanOnError
+	if err := es.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer es.Stop()
+
+	touch(t, tmp, "a")
+	es.Flush(true)
+	touch(t, tmp, "b")
+	es.Flush(true)
+
+	if len(batches) != 3 {
+		t.Fatalf("handler called with %d batches", len(batches))
+	}
+	id := batches[0][0].ID
+	want := formatBatches([][]Event{
+		{{Path: join(tmp, "a"), Flags: ItemCreated | ItemIsFile, ID: id}},
+		{{Path: tmp, Flags: MustScanSubDirs | UserDropped, ID: id}},
+		{{Path: join(tmp, "b"), Flags: ItemCreated | ItemIsFile, ID: batches[2][0].ID}},
+	})
+	if have := formatBatches(batches); have != want {
+		t.Errorf("\nhave: %s\nwant: %s", have, want)
+	}
+}
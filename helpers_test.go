@@ -1,3 +1,5 @@
+//go:build darwin || linux
+
 package fsevents
 
 import (
@@ -16,28 +18,6 @@ import (
 
 // EventFlags extensions for tests.
 
-var eventFlagsPossible = map[string]EventFlags{
-	strings.ToLower("MustScanSubDirs"):   MustScanSubDirs,
-	strings.ToLower("KernelDropped"):     KernelDropped,
-	strings.ToLower("UserDropped"):       UserDropped,
-	strings.ToLower("EventIDsWrapped"):   EventIDsWrapped,
-	strings.ToLower("HistoryDone"):       HistoryDone,
-	strings.ToLower("RootChanged"):       RootChanged,
-	strings.ToLower("Mount"):             Mount,
-	strings.ToLower("Unmount"):           Unmount,
-	strings.ToLower("ItemCreated"):       ItemCreated,
-	strings.ToLower("ItemRemoved"):       ItemRemoved,
-	strings.ToLower("ItemInodeMetaMod"):  ItemInodeMetaMod,
-	strings.ToLower("ItemRenamed"):       ItemRenamed,
-	strings.ToLower("ItemModified"):      ItemModified,
-	strings.ToLower("ItemFinderInfoMod"): ItemFinderInfoMod,
-	strings.ToLower("ItemChangeOwner"):   ItemChangeOwner,
-	strings.ToLower("ItemXattrMod"):      ItemXattrMod,
-	strings.ToLower("ItemIsFile"):        ItemIsFile,
-	strings.ToLower("ItemIsDir"):         ItemIsDir,
-	strings.ToLower("ItemIsSymlink"):     ItemIsSymlink,
-}
-
 func (flags EventFlags) set(mask EventFlags) EventFlags {
 	return flags | mask
 }
@@ -46,32 +26,25 @@ func (flags EventFlags) hasFlag(mask EventFlags) bool {
 	return flags&mask != 0
 }
 
-func (flags EventFlags) setFlags() []string {
-	var result []string
-
-	for k, f := range eventFlagsPossible {
-		if flags.hasFlag(f) {
-			result = append(result, k)
-		}
-	}
-
-	sort.Slice(result, func(i, j int) bool {
-		return result[i] < result[j]
-	})
-
-	return result
-}
-
-func (flags EventFlags) String() string {
-	setFlags := flags.setFlags()
-	return strings.Join(setFlags, "|")
-}
-
 // We wait a little bit after most commands; gives the system some time to sync
 // things and makes things more consistent.
 func eventSeparator() { time.Sleep(100 * time.Millisecond) }
 func waitForEvents()  { time.Sleep(500 * time.Millisecond) }
 
+// testDevice returns the device test streams for path should be relative to.
+// Device streams are only used on macOS; elsewhere this returns 0.
+func testDevice(t *testing.T, path string) int32 {
+	t.Helper()
+	if runtime.GOOS != "darwin" {
+		return 0
+	}
+	dev, err := DeviceForPath(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dev
+}
+
 // addWatch adds a watch for a directory
 func (w *eventCollector) addWatch(t *testing.T, path ...string) {
 	t.Helper()
@@ -86,15 +59,10 @@ func (w *eventCollector) addWatch(t *testing.T, path ...string) {
 		return
 	}
 
-	dev, err := DeviceForPath(p)
-	if err != nil {
-		t.Fatal(err)
-	}
-
 	es := &EventStream{
 		Paths:   []string{p},
 		Latency: 0,
-		Device:  dev,
+		Device:  testDevice(t, p),
 		Flags:   FileEvents | NoDefer,
 	}
 
@@ -136,6 +104,18 @@ func shouldWait(path ...string) bool {
 	return true
 }
 
+// tempDir returns a temporary directory, with the symlinks in its path
+// resolved as they are by the backends: on macOS it's under /var, a symlink to
+// /private/var.
+func tempDir(t *testing.T) string {
+	t.Helper()
+	tmp, err := filepath.EvalSymlinks(t.TempDir())
+	if err != nil {
+		t.Fatalf("tempDir: %s", err)
+	}
+	return tmp
+}
+
 // mkdir
 func mkdir(t *testing.T, path ...string) {
 	t.Helper()
@@ -377,10 +357,13 @@ func (e Events) TrimPrefix(prefix string) Events {
 	prefix = strings.TrimPrefix(prefix, "/")
 
 	for i := range e {
-		if e[i].Path == prefix {
+		// Paths are relative to the device root for device streams, and
+		// absolute otherwise.
+		p := strings.TrimPrefix(e[i].Path, "/")
+		if p == prefix {
 			e[i].Path = "/"
 		} else {
-			e[i].Path = strings.TrimPrefix(e[i].Path, prefix)
+			e[i].Path = strings.TrimPrefix(p, prefix)
 		}
 	}
 	return e
@@ -435,11 +418,9 @@ func newEvents(t *testing.T, s string) Events {
 			t.Fatalf("newEvents: line %d: needs 2 or 4 fields: %s", no+1, line)
 		}
 
-		var resultFlags EventFlags
-
-		flags := strings.Split(fields[0], "|")
-		for _, f := range flags {
-			resultFlags = resultFlags.set(eventFlagsPossible[strings.ToLower(f)])
+		resultFlags, err := ParseEventFlags(fields[0])
+		if err != nil {
+			t.Fatalf("newEvents: line %d: %s", no+1, err)
 		}
 
 		for _, g := range groups {
@@ -506,15 +487,11 @@ func parseScript(t *testing.T, in string) {
 		cmds  = make([]command, 0, 8)
 		readW bool
 		want  string
-		tmp   = t.TempDir()
-		err   error
+		readR bool
+		rens  []string
+		tmp   = tempDir(t)
 	)
 
-	tmp, err = filepath.EvalSymlinks(tmp)
-	if err != nil {
-		t.Fatalf("evalSymlinks: %v", err)
-	}
-
 	for i, line := range lines {
 		line = strings.TrimSpace(line)
 		if line == "" || line[0] == '#' {
@@ -527,6 +504,14 @@ func parseScript(t *testing.T, in string) {
 			readW = true
 			continue
 		}
+		if line == "Renames:" {
+			readW, readR = false, true
+			continue
+		}
+		if readR {
+			rens = append(rens, line)
+			continue
+		}
 		if readW {
 			want += line + "\n"
 			continue
@@ -693,5 +678,44 @@ loop:
 		d()
 	}
 	ev := w.stop()
+	renames := PairRenames(ev)
 	cmpEvents(t, tmp, ev, newEvents(t, want))
+	if readR {
+		cmpRenames(t, tmp, renames, rens)
+	}
+}
+
+// cmpRenames compares renames with the "Renames:" section of a script, which
+// has a line for every rename:
+//
+//	rename    /from /to
+//	moved-in  /to
+//	moved-out /from
+func cmpRenames(t *testing.T, tmp string, renames []Rename, want []string) {
+	t.Helper()
+
+	rel := func(path string) string {
+		p := strings.TrimPrefix(strings.TrimPrefix(path, "/"), strings.TrimPrefix(tmp, "/"))
+		if p == "" {
+			return "/"
+		}
+		return filepath.ToSlash(p)
+	}
+	var have []string
+	for _, r := range renames {
+		switch {
+		case r.MovedIn():
+			have = append(have, "moved-in "+rel(r.To))
+		case r.MovedOut():
+			have = append(have, "moved-out "+rel(r.From))
+		default:
+			have = append(have, "rename "+rel(r.From)+" "+rel(r.To))
+		}
+	}
+	for i := range want {
+		want[i] = strings.Join(strings.Fields(want[i]), " ")
+	}
+	if strings.Join(have, "\n") != strings.Join(want, "\n") {
+		t.Errorf("renames\nhave:\n\t%s\nwant:\n\t%s", strings.Join(have, "\n\t"), strings.Join(want, "\n\t"))
+	}
 }
@@ -0,0 +1,365 @@
+//go:build linux
+
+package fsevents
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"unsafe"
+)
+
+// inotifyMask is the set of inotify events requested for every directory.
+const inotifyMask = syscall.IN_CREATE | syscall.IN_DELETE | syscall.IN_MODIFY |
+	syscall.IN_ATTRIB | syscall.IN_MOVED_FROM | syscall.IN_MOVED_TO |
+	syscall.IN_DELETE_SELF | syscall.IN_MOVE_SELF |
+	syscall.IN_DONT_FOLLOW | syscall.IN_ONLYDIR
+
+// inotify is the backend that feeds an EventStream from inotify.
+//
+// inotify watches are not recursive, so every directory below a watched
+// directory gets its own watch, and new directories are added as they're
+// created. Watched files are implemented by watching their parent directory,
+// which gives the same path-based semantics as FSEvents: a watched file that
+// gets replaced is still watched.
+type inotify struct {
+	fdReader
+
+	roots []string // EventStream.Paths, resolved by start
+
+	// Only accessed by the reader after start.
+	watches map[int]*inotifyWatch // by watch descriptor
+	links   map[string]struct{}   // known symlinks, for typing removals
+	moves   map[uint32]string     // directories moved away, by cookie
+}
+
+// inotifyWatch is a single watched directory.
+type inotifyWatch struct {
+	wd   int
+	path string
+
+	// root is set if the directory itself is one of EventStream.Paths.
+	root bool
+
+	// tree is set if the directory is part of a watched hierarchy;
+	// events for all its entries are reported. If it isn't, only the
+	// entries listed in names are.
+	tree  bool
+	names map[string]struct{}
+}
+
+// nativeHistory is false as inotify has no history; it comes from a Journal.
+const nativeHistory = false
+
+func newNativeBackend(es *EventStream) (backend, error) {
+	if es.Device != 0 {
+		return newFanotify(es), nil
+	}
+	return newInotify(es), nil
+}
+
+func newInotify(es *EventStream) *inotify {
+	return &inotify{
+		fdReader: newFDReader(es),
+		watches:  make(map[int]*inotifyWatch),
+		links:    make(map[string]struct{}),
+		moves:    make(map[uint32]string),
+	}
+}
+
+func (w *inotify) start() error {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+	if err != nil {
+		return fmt.Errorf("fsevents: inotify_init1: %w", err)
+	}
+	if err := w.open(fd); err != nil {
+		return err
+	}
+	w.roots = w.roots[:0]
+
+	for _, p := range w.es.Paths {
+		if err := w.addRoot(p); err != nil {
+			w.close()
+			return err
+		}
+	}
+
+	go w.run(make([]byte, syscall.SizeofInotifyEvent*4096), w.convert, w.drained)
+	return nil
+}
+
+func (w *inotify) describe() Description {
+	return Description{
+		Text: fmt.Sprintf("inotify fd %d: paths = %q, latency = %s, flags = %#x",
+			w.fd, w.roots, w.es.Latency, uint32(w.es.Flags)),
+		Paths: append([]string(nil), w.roots...),
+	}
+}
+
+// addRoot starts watching one of EventStream.Paths.
+func (w *inotify) addRoot(path string) error {
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return newPathError("abs", path, err)
+	}
+	// Unlike FSEvents, which reports nothing for a path that's a symlink,
+	// watch the target: inotify_add_watch follows symlinks, and a watch on
+	// the link itself with IN_DONT_FOLLOW would only see changes to the
+	// link's own metadata. The events have the resolved paths.
+	path, err = filepath.EvalSymlinks(path)
+	if err != nil {
+		return newPathError("evalsymlinks", path, err)
+	}
+	fi, err := os.Lstat(path)
+	if err != nil {
+		return newPathError("lstat", path, err)
+	}
+	w.roots = append(w.roots, path)
+
+	if !fi.IsDir() {
+		dir, name := filepath.Split(path)
+		iw, err := w.addWatch(filepath.Clean(dir))
+		if err != nil {
+			return err
+		}
+		iw.names[name] = struct{}{}
+		return nil
+	}
+
+	if _, err := w.addTree(path); err != nil {
+		return err
+	}
+	for _, iw := range w.watches {
+		if iw.path == path {
+			iw.root = true
+		}
+	}
+	return nil
+}
+
+// addWatch adds a watch for the directory path, or returns the existing one.
+func (w *inotify) addWatch(path string) (*inotifyWatch, error) {
+	wd, err := syscall.InotifyAddWatch(w.fd, path, inotifyMask)
+	if err == syscall.ENOSPC {
+		return nil, fmt.Errorf("%w: inotify watch limit reached watching %q; see /proc/sys/fs/inotify/max_user_watches",
+			ErrTooManyPaths, path)
+	}
+	if err != nil {
+		return nil, newPathError("inotify_add_watch", path, err)
+	}
+
+	if iw, ok := w.watches[wd]; ok {
+		return iw, nil
+	}
+	iw := &inotifyWatch{wd: wd, path: path, names: make(map[string]struct{})}
+	w.watches[wd] = iw
+	return iw, nil
+}
+
+// addTree watches path and all directories below it, and returns the
+// events for all entries it found below path.
+func (w *inotify) addTree(path string) ([]Event, error) {
+	var found []Event
+	err := filepath.WalkDir(path, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			// Entries can disappear while walking a directory that's
+			// in use; that's not an error.
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			return newPathError("walk", p, err)
+		}
+
+		ino, link := statInode(p)
+		switch {
+		case d.IsDir():
+			iw, err := w.addWatch(p)
+			if err != nil {
+				if errors.Is(err, syscall.ENOENT) {
+					return filepath.SkipDir
+				}
+				return err
+			}
+			iw.tree = true
+			if p != path {
+				found = append(found, Event{Path: p, Flags: ItemCreated | ItemIsDir, Inode: ino})
+			}
+		case d.Type()&fs.ModeSymlink != 0:
+			w.links[p] = struct{}{}
+			found = append(found, Event{Path: p, Flags: ItemCreated | ItemIsSymlink, Inode: ino})
+		default:
+			flags := ItemCreated | ItemIsFile | link
+			// The file was written to before the watch was in place.
+			if fi, err := d.Info(); err == nil && fi.Size() > 0 {
+				flags |= ItemModified
+			}
+			found = append(found, Event{Path: p, Flags: flags, Inode: ino})
+		}
+		return nil
+	})
+	return found, err
+}
+
+// removeTree stops watching path and all directories below it.
+func (w *inotify) removeTree(path string) {
+	for wd, iw := range w.watches {
+		if iw.path == path || strings.HasPrefix(iw.path, path+"/") {
+			syscall.InotifyRmWatch(w.fd, uint32(wd))
+			delete(w.watches, wd)
+		}
+	}
+}
+
+// moveTree updates the paths of the watches below from after the directory
+// was renamed to to.
+func (w *inotify) moveTree(from, to string) {
+	for _, iw := range w.watches {
+		if iw.path == from {
+			iw.path = to
+		} else if strings.HasPrefix(iw.path, from+"/") {
+			iw.path = to + iw.path[len(from):]
+		}
+	}
+	for l := range w.links {
+		if strings.HasPrefix(l, from+"/") {
+			delete(w.links, l)
+			w.links[to+l[len(from):]] = struct{}{}
+		}
+	}
+}
+
+// drained is called after all pending events were read.
+func (w *inotify) drained() {
+	// Directories moved away without a matching IN_MOVED_TO left the
+	// watched hierarchy.
+	for cookie, path := range w.moves {
+		w.removeTree(path)
+		delete(w.moves, cookie)
+	}
+}
+
+// convert translates a buffer of raw inotify events into Events.
+func (w *inotify) convert(buf []byte) []Event {
+	var events []Event
+	for off := 0; off+syscall.SizeofInotifyEvent <= len(buf); {
+		raw := (*syscall.InotifyEvent)(unsafe.Pointer(&buf[off]))
+		nameBuf := buf[off+syscall.SizeofInotifyEvent : off+syscall.SizeofInotifyEvent+int(raw.Len)]
+		off += syscall.SizeofInotifyEvent + int(raw.Len)
+
+		name := strings.TrimRight(string(nameBuf), "\x00")
+		events = append(events, w.convertOne(int(raw.Wd), raw.Mask, raw.Cookie, name)...)
+	}
+	return events
+}
+
+func (w *inotify) convertOne(wd int, mask, cookie uint32, name string) []Event {
+	if mask&syscall.IN_Q_OVERFLOW != 0 {
+		var events []Event
+		for _, r := range w.roots {
+			events = append(events, Event{Path: r, Flags: MustScanSubDirs | KernelDropped})
+		}
+		return events
+	}
+
+	iw, ok := w.watches[wd]
+	if !ok {
+		return nil
+	}
+	if mask&syscall.IN_IGNORED != 0 {
+		delete(w.watches, wd)
+		return nil
+	}
+
+	path := iw.path
+	if name == "" {
+		// Events for the directory itself are reported by its parent,
+		// unless it's the root of a watched hierarchy.
+		if !iw.root {
+			return nil
+		}
+	} else {
+		if _, ok := iw.names[name]; !iw.tree && !ok {
+			return nil
+		}
+		path = filepath.Join(path, name)
+	}
+
+	var flags EventFlags
+	if mask&syscall.IN_CREATE != 0 {
+		flags |= ItemCreated
+	}
+	if mask&(syscall.IN_DELETE|syscall.IN_DELETE_SELF) != 0 {
+		flags |= ItemRemoved
+	}
+	if mask&(syscall.IN_MOVED_FROM|syscall.IN_MOVED_TO|syscall.IN_MOVE_SELF) != 0 {
+		flags |= ItemRenamed
+	}
+	if mask&syscall.IN_MODIFY != 0 {
+		flags |= ItemModified
+	}
+	if mask&syscall.IN_ATTRIB != 0 {
+		flags |= ItemInodeMetaMod
+	}
+	if flags == 0 {
+		return nil
+	}
+
+	ev := Event{Path: path, Flags: flags | w.itemType(path, mask)}
+	// The item is no longer at the path once it's removed or moved away.
+	if mask&(syscall.IN_DELETE|syscall.IN_DELETE_SELF|syscall.IN_MOVED_FROM|syscall.IN_MOVE_SELF) == 0 {
+		ino, link := statInode(path)
+		ev.Inode, ev.Flags = ino, ev.Flags|link
+	}
+	events := []Event{ev}
+
+	switch {
+	case name == "" && mask&(syscall.IN_DELETE_SELF|syscall.IN_MOVE_SELF) != 0:
+		// FSEvents watches paths, so a moved root isn't followed.
+		w.removeTree(path)
+		if w.es.Flags&WatchRoot != 0 {
+			events = append(events, Event{Path: path, Flags: RootChanged})
+		}
+	case mask&syscall.IN_ISDIR == 0:
+	case mask&syscall.IN_CREATE != 0 && iw.tree:
+		found, err := w.addTree(path)
+		if err != nil {
+			w.report(err)
+		}
+		events = append(events, found...)
+	case mask&syscall.IN_MOVED_FROM != 0:
+		w.moves[cookie] = path
+	case mask&syscall.IN_MOVED_TO != 0 && iw.tree:
+		if from, ok := w.moves[cookie]; ok {
+			delete(w.moves, cookie)
+			w.moveTree(from, path)
+		} else if _, err := w.addTree(path); err != nil {
+			w.report(err)
+		}
+	}
+	return events
+}
+
+// itemType returns the ItemIs* flag for path.
+func (w *inotify) itemType(path string, mask uint32) EventFlags {
+	if mask&syscall.IN_ISDIR != 0 || (mask&(syscall.IN_DELETE_SELF|syscall.IN_MOVE_SELF) != 0) {
+		return ItemIsDir
+	}
+
+	_, link := w.links[path]
+	if mask&(syscall.IN_DELETE|syscall.IN_MOVED_FROM) != 0 {
+		delete(w.links, path)
+	} else if fi, err := os.Lstat(path); err == nil {
+		link = fi.Mode()&fs.ModeSymlink != 0
+		if link {
+			w.links[path] = struct{}{}
+		}
+	}
+	if link {
+		return ItemIsSymlink
+	}
+	return ItemIsFile
+}
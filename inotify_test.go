@@ -0,0 +1,295 @@
+//go:build linux
+
+package fsevents
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestInotifyDirEvents(t *testing.T) {
+	tmp := t.TempDir()
+
+	es := &EventStream{
+		Paths:  []string{tmp},
+		Flags:  NoDefer,
+		Events: make(chan []Event, 16),
+	}
+	if err := es.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer es.Stop()
+
+	mkdir(t, tmp, "dir")
+	touch(t, tmp, "dir", "file")
+	echoAppend(t, "data", tmp, "dir", "file")
+
+	es.Flush(true)
+
+	var have Events
+	for len(es.Events) > 0 {
+		have = append(have, <-es.Events...)
+	}
+
+	want := Events{{Path: tmp}, {Path: filepath.Join(tmp, "dir")}, {Path: filepath.Join(tmp, "dir")}}
+	if have.String() != want.String() {
+		t.Errorf("\nhave:\n%s\nwant:\n%s", indent(have), indent(want))
+	}
+	for _, e := range have {
+		if e.ID == 0 {
+			t.Errorf("event without ID: %#v", e)
+		}
+	}
+}
+
+func TestInotifyFlush(t *testing.T) {
+	tmp := t.TempDir()
+
+	es := &EventStream{
+		Paths:  []string{tmp},
+		Flags:  FileEvents,
+		Events: make(chan []Event, 16),
+	}
+	if err := es.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer es.Stop()
+
+	if err := os.WriteFile(filepath.Join(tmp, "file"), nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	es.Flush(true)
+
+	select {
+	case msg := <-es.Events:
+		if len(msg) != 1 || msg[0].Flags != ItemCreated|ItemIsFile {
+			t.Errorf("unexpected events: %#v", msg)
+		}
+	default:
+		t.Fatal("no events after Flush(true)")
+	}
+}
+
+func TestInotifyLatency(t *testing.T) {
+	const latency = 300 * time.Millisecond
+	tmp := t.TempDir()
+
+	es := &EventStream{
+		Paths:   []string{tmp},
+		Flags:   FileEvents,
+		Latency: latency,
+		Events:  make(chan []Event, 16),
+	}
+	if err := es.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer es.Stop()
+
+	start := time.Now()
+	for _, name := range []string{"a", "b", "c"} {
+		touch(t, tmp, name)
+	}
+
+	select {
+	case msg := <-es.Events:
+		if len(msg) != 3 {
+			t.Errorf("have %d events in the batch, want 3: %v", len(msg), msg)
+		}
+		if took := time.Since(start); took < latency {
+			t.Errorf("delivered after %s; latency is %s", took, latency)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out")
+	}
+}
+
+func TestInotifyErrors(t *testing.T) {
+	tmp := t.TempDir()
+
+	es := &EventStream{Paths: []string{filepath.Join(tmp, "missing")}}
+	err := es.Start()
+	if err == nil {
+		es.Stop()
+		t.Fatal("started watching a missing path")
+	}
+	var pe *PathError
+	if !errors.Is(err, ErrPathNotFound) || !errors.Is(err, fs.ErrNotExist) || !errors.As(err, &pe) {
+		t.Errorf("Start returned %#v, want a PathError matching ErrPathNotFound", err)
+	}
+
+	es = &EventStream{Paths: []string{tmp}}
+	if err := es.Start(); err != nil {
+		t.Fatal(err)
+	}
+	if err := es.Start(); !errors.Is(err, ErrAlreadyStarted) {
+		t.Errorf("second Start returned %v, want %v", err, ErrAlreadyStarted)
+	}
+	es.Stop()
+
+	if os.Geteuid() == 0 {
+		t.Skip("permissions are not checked for root")
+	}
+	mkdir(t, tmp, "private")
+	mkdir(t, tmp, "private", "dir")
+	chmod(t, 0, tmp, "private")
+	defer chmod(t, 0o755, tmp, "private")
+
+	es = &EventStream{Paths: []string{tmp}}
+	if err := es.Start(); !errors.Is(err, ErrPermission) {
+		es.Stop()
+		t.Errorf("Start returned %v, want %v", err, ErrPermission)
+	}
+}
+
+func TestInotifyDescribe(t *testing.T) {
+	tmp := t.TempDir()
+	mkdir(t, tmp, "dir")
+	symlink(t, join(tmp, "dir"), tmp, "link")
+
+	es := &EventStream{Paths: []string{join(tmp, "link")}}
+	if err := es.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer es.Stop()
+
+	// The paths are the ones being watched, after resolving symlinks.
+	d := es.Describe()
+	if len(d.Paths) != 1 || d.Paths[0] != join(tmp, "dir") {
+		t.Errorf("Paths is %q", d.Paths)
+	}
+	if !strings.HasPrefix(d.Text, "inotify fd ") {
+		t.Errorf("Text is %q", d.Text)
+	}
+}
+
+func TestInotifyOverflow(t *testing.T) {
+	w := &inotify{roots: []string{"/tmp/dir"}}
+	w.es = &EventStream{Paths: []string{"link"}}
+
+	// The events are for the paths being watched, after resolving them.
+	have := Events(w.convertOne(-1, syscall.IN_Q_OVERFLOW, 0, ""))
+	want := Events{{Path: "/tmp/dir", Flags: MustScanSubDirs | KernelDropped}}
+	if have.String() != want.String() {
+		t.Errorf("\nhave:\n%s\nwant:\n%s", indent(have), indent(want))
+	}
+}
+
+func TestInotifyReconfigureError(t *testing.T) {
+	tmp := t.TempDir()
+
+	es := &EventStream{
+		Paths:  []string{tmp},
+		Flags:  FileEvents | NoDefer,
+		Events: make(chan []Event, 16),
+	}
+	if err := es.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer es.Stop()
+
+	err := es.Reconfigure([]string{join(tmp, "missing")}, FileEvents|NoDefer, 0)
+	if !errors.Is(err, ErrPathNotFound) {
+		t.Fatalf("Reconfigure returned %v", err)
+	}
+	if len(es.Paths) != 1 || es.Paths[0] != tmp {
+		t.Errorf("Paths was changed to %q", es.Paths)
+	}
+
+	// The stream keeps running with the old configuration.
+	touch(t, tmp, "file")
+	select {
+	case have := <-es.Events:
+		if have[0].Path != join(tmp, "file") {
+			t.Errorf("received %v", have)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no events after the failed Reconfigure")
+	}
+}
+
+func TestInotifyReconfigureLatency(t *testing.T) {
+	tmp := t.TempDir()
+
+	es := &EventStream{
+		Paths:   []string{tmp},
+		Flags:   FileEvents,
+		Latency: time.Hour,
+		Events:  make(chan []Event, 16),
+	}
+	if err := es.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer es.Stop()
+
+	// The events waiting for Latency when the stream is reconfigured are
+	// delivered by the old stream, as the new one doesn't see them.
+	touch(t, tmp, "file")
+	time.Sleep(10 * time.Millisecond)
+	if err := es.Reconfigure([]string{tmp}, FileEvents|NoDefer, 0); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case have := <-es.Events:
+		if have[0].Path != join(tmp, "file") {
+			t.Errorf("received %v", have)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the pending events were lost")
+	}
+}
+
+func TestInotifyInode(t *testing.T) {
+	tmp := t.TempDir()
+
+	es := &EventStream{
+		Paths:  []string{tmp},
+		Flags:  FileEvents | NoDefer,
+		Events: make(chan []Event, 16),
+	}
+	if err := es.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer es.Stop()
+
+	receive := func() []Event {
+		t.Helper()
+		es.Flush(true)
+		var have []Event
+		for len(es.Events) > 0 {
+			have = append(have, <-es.Events...)
+		}
+		return have
+	}
+
+	touch(t, tmp, "file")
+	if err := os.Link(join(tmp, "file"), join(tmp, "link")); err != nil {
+		t.Fatal(err)
+	}
+	fi, err := os.Lstat(join(tmp, "file"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ino := fi.Sys().(*syscall.Stat_t).Ino
+
+	// The file may have been stat'ed before the link was made.
+	have := receive()
+	if len(have) != 2 || have[0].Inode != ino || have[0].Flags&^ItemIsHardlink != ItemCreated|ItemIsFile {
+		t.Fatalf("received %+v, want the file with inode %d", have, ino)
+	}
+	if have[1].Inode != ino || have[1].Flags != ItemCreated|ItemIsFile|ItemIsHardlink {
+		t.Errorf("received %+v, want the link with inode %d", have[1], ino)
+	}
+
+	// The item is gone, so its inode isn't known.
+	rm(t, tmp, "link")
+	have = receive()
+	if len(have) != 1 || have[0].Inode != 0 || have[0].Flags != ItemRemoved|ItemIsFile {
+		t.Errorf("received %+v", have)
+	}
+}
@@ -0,0 +1,43 @@
+//go:build go1.23 && (darwin || linux)
+
+package fsevents
+
+import (
+	"iter"
+)
+
+// All returns an iterator over the events received from Events, one at a
+// time. It ends once Events is closed by Stop, or when the stream stops
+// otherwise; Err then reports why.
+//
+//	for event := range es.All() {
+//		...
+//	}
+//	if err := es.Err(); err != nil {
+//		...
+//	}
+//
+// The stream must be started, and neither Handler nor FlatEvents set.
+func (es *EventStream) All() iter.Seq[Event] {
+	return func(yield func(Event) bool) {
+		for batch := range es.Events {
+			for _, e := range batch {
+				if !yield(e) {
+					return
+				}
+			}
+		}
+	}
+}
+
+// Batches returns an iterator over the batches received from Events, like
+// All.
+func (es *EventStream) Batches() iter.Seq[[]Event] {
+	return func(yield func([]Event) bool) {
+		for batch := range es.Events {
+			if !yield(batch) {
+				return
+			}
+		}
+	}
+}
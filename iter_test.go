@@ -0,0 +1,88 @@
+//go:build go1.23 && (darwin || linux)
+
+package fsevents
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestAll(t *testing.T) {
+	tmp := tempDir(t)
+	es := &EventStream{
+		Paths:        []string{tmp},
+		Flags:        FileEvents,
+		Backend:      PollBackend,
+		PollInterval: time.Hour,
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	if _, err := es.StartContext(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	go func() {
+		touch(t, tmp, "a")
+		touch(t, tmp, "b")
+		es.Flush(true)
+		cancel()
+	}()
+
+	var have Events
+	for e := range es.All() {
+		have = append(have, e)
+	}
+	want := Events{
+		{Path: join(tmp, "a"), Flags: ItemCreated | ItemIsFile},
+		{Path: join(tmp, "b"), Flags: ItemCreated | ItemIsFile},
+	}
+	if have.String() != want.String() {
+		t.Errorf("\nhave:\n%s\nwant:\n%s", indent(have), indent(want))
+	}
+	if err := es.Err(); !errors.Is(err, context.Canceled) {
+		t.Errorf("Err returned %v", err)
+	}
+}
+
+func TestBatches(t *testing.T) {
+	tmp := tempDir(t)
+	es := &EventStream{
+		Paths:        []string{tmp},
+		Flags:        FileEvents,
+		Backend:      PollBackend,
+		PollInterval: time.Hour,
+	}
+	if err := es.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer es.Stop()
+
+	go func() {
+		touch(t, tmp, "a")
+		es.Flush(false)
+	}()
+
+	// Breaking out of the loop leaves the stream running.
+	for batch := range es.Batches() {
+		if len(batch) != 1 || batch[0].Path != join(tmp, "a") {
+			t.Errorf("received %v", batch)
+		}
+		break
+	}
+	go func() {
+		touch(t, tmp, "b")
+		es.Flush(true)
+		es.Stop()
+	}()
+	var n int
+	for range es.Batches() {
+		n++
+	}
+	if n != 1 {
+		t.Errorf("received %d batches after the first one", n)
+	}
+	if err := es.Err(); err != nil {
+		t.Errorf("Err returned %v after Stop", err)
+	}
+}
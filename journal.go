@@ -0,0 +1,437 @@
+//go:build darwin || linux
+
+package fsevents
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// defaultJournalSize is the number of events kept by a Journal if no size is
+// given to OpenJournal.
+const defaultJournalSize = 100000
+
+// Journal is an on-disk record of the events delivered by EventStreams, so
+// that Resume and EventID work with the backends that don't get them from
+// FSEvents: inotify, fanotify and PollBackend. Streams using it get event IDs
+// that keep increasing across restarts of the process, and with Resume set
+// first receive the recorded events after EventID, followed by an event with
+// the HistoryDone flag.
+//
+// Like the FSEvents database a journal should be considered advisory: it only
+// has the events delivered while a stream was running, and only keeps the most
+// recent ones. If some of the events after EventID are no longer kept, the
+// history starts with a MustScanSubDirs|UserDropped event for every path.
+//
+// A journal can be shared by any number of streams, but only by a single
+// process at a time.
+type Journal struct {
+	path string
+	size int
+
+	mu      sync.Mutex
+	f       *os.File
+	w       *bufio.Writer
+	lines   int // batches in the file
+	batches []journalBatch
+	events  int    // events in batches
+	dropped uint64 // ID of the last event dropped from batches
+	err     error  // first write error
+}
+
+// journalBatch is a line of the journal file.
+type journalBatch struct {
+	Time   time.Time     `json:"time"`
+	Device int32         `json:"device,omitempty"`
+	Events []recordEvent `json:"events"`
+}
+
+// errJournalClosed is returned by Start for a stream with a closed Journal.
+var errJournalClosed = errors.New("fsevents: journal is closed")
+
+var (
+	journalsMu sync.Mutex
+	journals   = make(map[*Journal]struct{}) // open journals
+)
+
+// OpenJournal opens or creates the journal at path, keeping at most size
+// events. If size is zero or negative 100,000 events are kept.
+//
+// The event IDs of all streams continue after the latest ID in the journal.
+func OpenJournal(path string, size int) (*Journal, error) {
+	if size <= 0 {
+		size = defaultJournalSize
+	}
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("fsevents: %w", err)
+	}
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("fsevents: journal %q is in use: %w", path, err)
+	}
+
+	j := &Journal{path: path, size: size, f: f}
+	complete, err := j.read()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	j.trim()
+	if !complete || j.lines > len(j.batches) {
+		// Drop what was left of a partial write, and anything that's no
+		// longer kept.
+		if err := j.compact(); err != nil {
+			j.f.Close()
+			return nil, err
+		}
+	}
+	j.w = bufio.NewWriter(j.f)
+
+	// Continue the event IDs after the latest in the journal.
+	latest := j.latest()
+	for {
+		id := atomic.LoadUint64(&lastEventID)
+		if id >= latest || atomic.CompareAndSwapUint64(&lastEventID, id, latest) {
+			break
+		}
+	}
+
+	journalsMu.Lock()
+	journals[j] = struct{}{}
+	journalsMu.Unlock()
+	return j, nil
+}
+
+// Close writes the events that are still buffered and closes the journal.
+func (j *Journal) Close() error {
+	journalsMu.Lock()
+	delete(journals, j)
+	journalsMu.Unlock()
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if j.f == nil {
+		return nil
+	}
+	err := j.w.Flush()
+	if err2 := j.f.Sync(); err == nil {
+		err = err2
+	}
+	if err2 := j.f.Close(); err == nil {
+		err = err2
+	}
+	j.f = nil
+	if err != nil {
+		return fmt.Errorf("fsevents: %w", err)
+	}
+	return nil
+}
+
+func (j *Journal) closed() bool {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.f == nil
+}
+
+// Err returns the first error writing to the journal. Events are still
+// delivered after an error, but no longer recorded.
+func (j *Journal) Err() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.err
+}
+
+// LatestEventID returns the ID of the most recent event in the journal.
+func (j *Journal) LatestEventID() uint64 {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.latest()
+}
+
+// EventIDForDeviceBeforeTime returns the ID of the last event in the journal
+// for the device dev before the given time, or zero if there is none. Host
+// streams have the device 0.
+func (j *Journal) EventIDForDeviceBeforeTime(dev int32, before time.Time) uint64 {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	var id uint64
+	for _, b := range j.batches {
+		if !b.Time.Before(before) {
+			break
+		}
+		if b.Device != dev {
+			continue
+		}
+		for _, e := range b.Events {
+			if e.ID > id {
+				id = e.ID
+			}
+		}
+	}
+	return id
+}
+
+// read loads the batches from the file. It returns false if the last line is
+// incomplete or invalid.
+func (j *Journal) read() (bool, error) {
+	s := bufio.NewScanner(j.f)
+	s.Buffer(nil, 64<<20)
+	for s.Scan() {
+		var b journalBatch
+		if err := json.Unmarshal(s.Bytes(), &b); err != nil {
+			return false, nil
+		}
+		j.batches = append(j.batches, b)
+		j.events += len(b.Events)
+		j.lines++
+	}
+	if err := s.Err(); err != nil {
+		return false, fmt.Errorf("fsevents: reading journal: %w", err)
+	}
+	return true, nil
+}
+
+// trim drops the oldest batches until the journal has at most j.size events.
+func (j *Journal) trim() {
+	n := 0
+	for ; n < len(j.batches)-1 && j.events > j.size; n++ {
+		for _, e := range j.batches[n].Events {
+			if e.ID > j.dropped {
+				j.dropped = e.ID
+			}
+		}
+		j.events -= len(j.batches[n].Events)
+	}
+	if n > 0 {
+		j.batches = append([]journalBatch(nil), j.batches[n:]...)
+	}
+}
+
+// compact rewrites the file with only the batches that are kept.
+func (j *Journal) compact() error {
+	tmp, err := os.CreateTemp(filepath.Dir(j.path), filepath.Base(j.path)+".*")
+	if err != nil {
+		return fmt.Errorf("fsevents: compacting journal: %w", err)
+	}
+	w := bufio.NewWriter(tmp)
+	enc := json.NewEncoder(w)
+	for _, b := range j.batches {
+		if err := enc.Encode(b); err != nil {
+			tmp.Close()
+			os.Remove(tmp.Name())
+			return fmt.Errorf("fsevents: compacting journal: %w", err)
+		}
+	}
+	if err := w.Flush(); err == nil {
+		err = tmp.Sync()
+	}
+	if err == nil {
+		err = syscall.Flock(int(tmp.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), j.path)
+	}
+	if err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return fmt.Errorf("fsevents: compacting journal: %w", err)
+	}
+
+	j.f.Close()
+	j.f = tmp
+	j.lines = len(j.batches)
+	if j.w != nil {
+		j.w.Reset(j.f)
+	}
+	return nil
+}
+
+// latest returns the ID of the most recent event; j.mu must be held.
+func (j *Journal) latest() uint64 {
+	id := j.dropped
+	for _, b := range j.batches {
+		for _, e := range b.Events {
+			if e.ID > id {
+				id = e.ID
+			}
+		}
+	}
+	return id
+}
+
+// record adds a batch delivered by a stream for the device dev. It returns
+// the error that stopped the journal from being written, the first time.
+func (j *Journal) record(dev int32, events []Event) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if j.f == nil || len(events) == 0 {
+		return nil
+	}
+	b := journalBatch{Time: time.Now(), Device: dev, Events: newRecordEvents(events)}
+	j.batches = append(j.batches, b)
+	j.events += len(b.Events)
+	j.trim()
+
+	if j.err != nil {
+		return nil
+	}
+	err := json.NewEncoder(j.w).Encode(b)
+	if err == nil {
+		err = j.w.Flush()
+	}
+	if err == nil {
+		j.lines++
+		// Compact once the file has as many dropped batches as kept ones.
+		if j.lines > 2*len(j.batches)+64 {
+			err = j.compact()
+		}
+	}
+	if err != nil {
+		j.err = fmt.Errorf("fsevents: writing journal: %w", err)
+		return j.err
+	}
+	return nil
+}
+
+// history returns the batches the stream es should receive when it resumes
+// from EventID: the recorded events for its device and paths after EventID.
+func (j *Journal) history(es *EventStream) [][]Event {
+	roots := make([]string, 0, len(es.Paths))
+	for _, p := range es.Paths {
+		roots = append(roots, journalRoot(p, es.Device != 0))
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	var history [][]Event
+	if es.EventID < j.dropped {
+		dropped := make([]Event, 0, len(es.Paths))
+		for _, p := range es.Paths {
+			dropped = append(dropped, Event{Path: p, Flags: MustScanSubDirs | UserDropped})
+		}
+		history = append(history, dropped)
+	}
+	for _, b := range j.batches {
+		if b.Device != es.Device {
+			continue
+		}
+		var events []Event
+		for _, e := range eventsFromRecord(b.Events) {
+			if e.ID > es.EventID && underRoots(e.Path, roots) {
+				events = append(events, e)
+			}
+		}
+		if len(events) > 0 {
+			if es.Flags&FileEvents == 0 {
+				events = dirEvents(events)
+			}
+			history = append(history, events)
+		}
+	}
+	return history
+}
+
+// journalRoot returns the path events below p are reported with.
+func journalRoot(p string, device bool) string {
+	if device {
+		return strings.Trim(filepath.Clean(p), "/")
+	}
+	if abs, err := filepath.Abs(p); err == nil {
+		p = abs
+	}
+	if r, err := filepath.EvalSymlinks(p); err == nil {
+		p = r
+	}
+	return p
+}
+
+// underRoots reports if path is one of roots or below one.
+func underRoots(path string, roots []string) bool {
+	for _, r := range roots {
+		if r == "" || r == "." || r == "/" || path == r ||
+			strings.HasPrefix(path, strings.TrimSuffix(r, "/")+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// journalBackend wraps the backend of a stream that uses a Journal: the
+// recorded history is delivered first if the stream resumes, and the backend's
+// events are held back until it has been.
+type journalBackend struct {
+	backend
+	es *EventStream
+
+	done   chan struct{} // closed by stop
+	exited chan struct{} // closed when the history has been delivered
+}
+
+func newJournalBackend(es *EventStream, b backend) *journalBackend {
+	return &journalBackend{
+		backend: b,
+		es:      es,
+		done:    make(chan struct{}),
+		exited:  make(chan struct{}),
+	}
+}
+
+func (jb *journalBackend) start() error {
+	if !jb.es.Resume {
+		close(jb.exited)
+		return jb.backend.start()
+	}
+
+	// The backend's events are held back by send until exited is closed,
+	// so taking the history after it's started doesn't miss any.
+	jb.es.gate = jb.exited
+	if err := jb.backend.start(); err != nil {
+		jb.es.gate = nil
+		return err
+	}
+	history := jb.es.Journal.history(jb.es)
+	go jb.run(history)
+	return nil
+}
+
+func (jb *journalBackend) flush(sync bool) {
+	if sync {
+		select {
+		case <-jb.exited:
+		case <-jb.done:
+		}
+	}
+	jb.backend.flush(sync)
+}
+
+func (jb *journalBackend) stop() {
+	close(jb.done)
+	jb.backend.stop()
+	<-jb.exited
+	jb.es.gate = nil
+}
+
+// run delivers the history, followed by the HistoryDone event.
+func (jb *journalBackend) run(history [][]Event) {
+	defer close(jb.exited)
+
+	for _, events := range append(history, []Event{{Flags: HistoryDone}}) {
+		if !jb.es.deliver(events, jb.done) {
+			return
+		}
+	}
+}
@@ -0,0 +1,31 @@
+//go:build linux
+
+package fsevents
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// LatestEventID returns the most recently generated event ID, by any stream of
+// this process. Once a Journal has been opened this includes the events
+// recorded in it by earlier processes.
+func LatestEventID() uint64 {
+	return atomic.LoadUint64(&lastEventID)
+}
+
+// EventIDForDeviceBeforeTime returns the ID of the last event for the device
+// dev before a given time, from the open journals. It returns zero if there is
+// none.
+func EventIDForDeviceBeforeTime(dev int32, before time.Time) uint64 {
+	journalsMu.Lock()
+	defer journalsMu.Unlock()
+
+	var id uint64
+	for j := range journals {
+		if jid := j.EventIDForDeviceBeforeTime(dev, before); jid > id {
+			id = jid
+		}
+	}
+	return id
+}
@@ -0,0 +1,284 @@
+//go:build darwin || linux
+
+package fsevents
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestJournalResume(t *testing.T) {
+	var (
+		tmp  = tempDir(t)
+		path = filepath.Join(t.TempDir(), "journal")
+	)
+	j, err := OpenJournal(path, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := OpenJournal(path, 0); err == nil {
+		t.Fatal("journal opened twice")
+	}
+
+	newStream := func() *EventStream {
+		return &EventStream{
+			Paths:        []string{tmp},
+			Flags:        FileEvents,
+			Backend:      PollBackend,
+			PollInterval: time.Hour,
+			Events:       make(chan []Event, 16),
+			Journal:      j,
+		}
+	}
+	es := newStream()
+	if err := es.Start(); err != nil {
+		t.Fatal(err)
+	}
+	touch(t, tmp, "a")
+	es.Flush(true)
+	<-es.Events
+	resumeFrom := es.EventID
+	touch(t, tmp, "b")
+	es.Flush(true)
+	es.Stop()
+	last := es.EventID
+	if err := j.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// A new process continues the IDs of the journal.
+	lastEventID = 0
+	j, err = OpenJournal(path, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer j.Close()
+	if j.LatestEventID() != last || LatestEventID() < last {
+		t.Fatalf("LatestEventID is %d and %d, want %d", j.LatestEventID(), LatestEventID(), last)
+	}
+
+	es = newStream()
+	es.Resume = true
+	es.EventID = resumeFrom
+	if err := es.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer es.Stop()
+	touch(t, tmp, "c")
+	es.Flush(true)
+
+	var have Events
+	for len(es.Events) > 0 {
+		have = append(have, <-es.Events...)
+	}
+	want := Events{
+		{Path: "/b", Flags: ItemCreated | ItemIsFile},
+		{Flags: HistoryDone},
+		{Path: "/c", Flags: ItemCreated | ItemIsFile},
+	}
+	if have.TrimPrefix(tmp).String() != want.String() {
+		t.Fatalf("\nhave:\n%s\nwant:\n%s", indent(have), indent(want))
+	}
+	if have[0].ID != last || have[2].ID <= last {
+		t.Errorf("wrong IDs: %d and %d after %d", have[0].ID, have[2].ID, last)
+	}
+}
+
+func TestJournalAck(t *testing.T) {
+	tmp := tempDir(t)
+	j, err := OpenJournal(filepath.Join(t.TempDir(), "journal"), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer j.Close()
+
+	es := &EventStream{
+		Paths:        []string{tmp},
+		Flags:        FileEvents,
+		Backend:      PollBackend,
+		PollInterval: time.Hour,
+		Journal:      j,
+	}
+	if err := es.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer es.Stop()
+
+	// Checkpoint while the stream is running.
+	var (
+		wg   sync.WaitGroup
+		done = make(chan struct{})
+	)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-done:
+				return
+			default:
+				es.LatestEventID()
+				es.AckedEventID()
+			}
+		}
+	}()
+	defer func() {
+		close(done)
+		wg.Wait()
+	}()
+
+	receive := func() []Event {
+		t.Helper()
+		select {
+		case msg := <-es.Events:
+			return msg
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for events")
+			return nil
+		}
+	}
+
+	touch(t, tmp, "a")
+	go es.Flush(false)
+	a := receive()
+	es.Ack(a[len(a)-1].ID)
+
+	touch(t, tmp, "b")
+	go es.Flush(false)
+	b := receive()
+	if have, want := es.LatestEventID(), b[len(b)-1].ID; have != want {
+		t.Errorf("LatestEventID is %d, want %d", have, want)
+	}
+	if have, want := es.AckedEventID(), a[len(a)-1].ID; have != want {
+		t.Errorf("AckedEventID is %d, want %d", have, want)
+	}
+
+	// b wasn't acknowledged, so it's delivered again.
+	if err := es.Restart(); err != nil {
+		t.Fatal(err)
+	}
+	have := Events(receive())
+	have = append(have, receive()...)
+	want := Events{
+		{Path: "/b", Flags: ItemCreated | ItemIsFile},
+		{Flags: HistoryDone},
+	}
+	if have.TrimPrefix(tmp).String() != want.String() {
+		t.Fatalf("\nhave:\n%s\nwant:\n%s", indent(have), indent(want))
+	}
+}
+
+func TestJournalSize(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "journal")
+	j, err := OpenJournal(path, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	for i := uint64(1); i <= 4; i++ {
+		j.record(0, []Event{{Path: "/dir/" + string(rune('a'+i)), Flags: ItemModified | ItemIsFile, ID: 100 + i}})
+	}
+	j.record(1, []Event{{Path: "dir/file", Flags: ItemModified | ItemIsFile, ID: 105}})
+	if err := j.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// A partial write is dropped.
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(`{"time":"2`)
+	f.Close()
+
+	j, err = OpenJournal(path, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer j.Close()
+
+	if id := j.EventIDForDeviceBeforeTime(0, time.Now()); id != 104 {
+		t.Errorf("EventIDForDeviceBeforeTime(0) = %d, want 104", id)
+	}
+	if id := j.EventIDForDeviceBeforeTime(1, time.Now()); id != 105 {
+		t.Errorf("EventIDForDeviceBeforeTime(1) = %d, want 105", id)
+	}
+	if id := j.EventIDForDeviceBeforeTime(0, start); id != 0 {
+		t.Errorf("EventIDForDeviceBeforeTime(0, start) = %d, want 0", id)
+	}
+
+	tests := []struct {
+		eventID uint64
+		flags   CreateFlags
+		want    Events
+	}{
+		{101, FileEvents, Events{
+			{Path: "/dir", Flags: MustScanSubDirs | UserDropped},
+			{Path: "/dir/e", Flags: ItemModified | ItemIsFile},
+		}},
+		{103, FileEvents, Events{
+			{Path: "/dir/e", Flags: ItemModified | ItemIsFile},
+		}},
+		{103, 0, Events{{Path: "/dir"}}},
+		{104, FileEvents, nil},
+	}
+	for _, tt := range tests {
+		var have Events
+		for _, b := range j.history(&EventStream{Paths: []string{"/dir"}, Flags: tt.flags, EventID: tt.eventID}) {
+			have = append(have, b...)
+		}
+		if have.String() != tt.want.String() {
+			t.Errorf("history after %d:\nhave:\n%s\nwant:\n%s", tt.eventID, indent(have), indent(tt.want))
+		}
+	}
+}
+
+func TestJournalErrors(t *testing.T) {
+	tmp := tempDir(t)
+	j, err := OpenJournal(filepath.Join(t.TempDir(), "journal"), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer j.Close()
+
+	es := &EventStream{
+		Paths:        []string{tmp},
+		Flags:        FileEvents,
+		Backend:      PollBackend,
+		PollInterval: time.Hour,
+		Events:       make(chan []Event, 16),
+		Errors:       make(chan error, 1),
+		Journal:      j,
+	}
+	if err := es.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer es.Stop()
+
+	// Make writing the journal fail.
+	j.f.Close()
+
+	touch(t, tmp, "a")
+	es.Flush(true)
+	touch(t, tmp, "b")
+	es.Flush(true)
+
+	if len(es.Events) != 2 {
+		t.Errorf("have %d batches, want 2", len(es.Events))
+	}
+	select {
+	case err := <-es.Errors:
+		if !errors.Is(err, os.ErrClosed) || !errors.Is(j.Err(), os.ErrClosed) {
+			t.Errorf("have errors %v and %v, want %v", err, j.Err(), os.ErrClosed)
+		}
+	default:
+		t.Fatal("no error reported")
+	}
+	if len(es.Errors) != 0 {
+		t.Error("the error was reported more than once")
+	}
+}
@@ -0,0 +1,70 @@
+package fsevents
+
+import (
+	"sync/atomic"
+)
+
+// Logger receives the diagnostics of the package: streams starting, stopping
+// and restarting, errors that occur while they're running, dropped batches,
+// and events FSEvents delivers for streams that were already stopped.
+//
+// The methods take a message and alternating keys and values, like log/slog,
+// so a *slog.Logger can be used as a Logger. The keys are "paths", "device"
+// and "event_id" for the stream, "err" for errors, and "batches" and
+// "events" for counts.
+type Logger interface {
+	Debug(msg string, args ...interface{})
+	Info(msg string, args ...interface{})
+	Warn(msg string, args ...interface{})
+	Error(msg string, args ...interface{})
+}
+
+// loggerValue wraps the package Logger, as an atomic.Value can't hold nil.
+type loggerValue struct {
+	Logger
+}
+
+var defaultLogger atomic.Value
+
+// SetLogger sets the Logger for the package, which is used by the streams that
+// don't have their own Logger. A nil Logger, the default, discards everything.
+func SetLogger(l Logger) {
+	defaultLogger.Store(loggerValue{l})
+}
+
+// packageLogger returns the Logger set with SetLogger, or one that discards
+// everything.
+func packageLogger() Logger {
+	if v, ok := defaultLogger.Load().(loggerValue); ok && v.Logger != nil {
+		return v.Logger
+	}
+	return nopLogger{}
+}
+
+type nopLogger struct{}
+
+func (nopLogger) Debug(string, ...interface{}) {}
+func (nopLogger) Info(string, ...interface{})  {}
+func (nopLogger) Warn(string, ...interface{})  {}
+func (nopLogger) Error(string, ...interface{}) {}
+
+// attrLogger adds attributes to everything it logs.
+type attrLogger struct {
+	l     Logger
+	attrs []interface{}
+}
+
+// withAttrs returns a Logger that adds the keys and values in attrs to
+// everything l logs.
+func withAttrs(l Logger, attrs ...interface{}) Logger {
+	return attrLogger{l: l, attrs: attrs}
+}
+
+func (l attrLogger) args(args []interface{}) []interface{} {
+	return append(append(make([]interface{}, 0, len(l.attrs)+len(args)), l.attrs...), args...)
+}
+
+func (l attrLogger) Debug(msg string, args ...interface{}) { l.l.Debug(msg, l.args(args)...) }
+func (l attrLogger) Info(msg string, args ...interface{})  { l.l.Info(msg, l.args(args)...) }
+func (l attrLogger) Warn(msg string, args ...interface{})  { l.l.Warn(msg, l.args(args)...) }
+func (l attrLogger) Error(msg string, args ...interface{}) { l.l.Error(msg, l.args(args)...) }
@@ -0,0 +1,113 @@
+//go:build darwin || linux
+
+package fsevents
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testLogger records what's logged as "level msg key=value ...".
+type testLogger struct {
+	mu    sync.Mutex
+	lines []string
+}
+
+func (l *testLogger) log(level, msg string, args []interface{}) {
+	line := level + " " + msg
+	for i := 0; i+1 < len(args); i += 2 {
+		line += fmt.Sprintf(" %v=%v", args[i], args[i+1])
+	}
+	l.mu.Lock()
+	l.lines = append(l.lines, line)
+	l.mu.Unlock()
+}
+
+func (l *testLogger) Debug(msg string, args ...interface{}) { l.log("DEBUG", msg, args) }
+func (l *testLogger) Info(msg string, args ...interface{})  { l.log("INFO", msg, args) }
+func (l *testLogger) Warn(msg string, args ...interface{})  { l.log("WARN", msg, args) }
+func (l *testLogger) Error(msg string, args ...interface{}) { l.log("ERROR", msg, args) }
+
+func (l *testLogger) String() string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return strings.Join(l.lines, "\n")
+}
+
+func TestLogger(t *testing.T) {
+	tmp := tempDir(t)
+	log := new(testLogger)
+	es := &EventStream{
+		Paths:        []string{tmp},
+		Backend:      PollBackend,
+		PollInterval: time.Hour,
+		EventID:      42,
+		Logger:       log,
+	}
+	if err := es.Start(); err != nil {
+		t.Fatal(err)
+	}
+	if err := es.Restart(); err != nil {
+		t.Fatal(err)
+	}
+	es.sendError(errors.New("oops"), nil)
+	es.Stop()
+
+	attrs := fmt.Sprintf("paths=[%s] device=0", tmp)
+	want := strings.Join([]string{
+		"DEBUG fsevents: starting " + attrs + " event_id=42 resume=false backend=PollBackend",
+		"DEBUG fsevents: stopped " + attrs + " event_id=42",
+		"DEBUG fsevents: restarting " + attrs + " event_id=42",
+		"DEBUG fsevents: starting " + attrs + " event_id=42 resume=true backend=PollBackend",
+		"ERROR fsevents: stream error " + attrs + " err=oops",
+		"DEBUG fsevents: stopped " + attrs + " event_id=42",
+	}, "\n")
+	if have := log.String(); have != want {
+		t.Errorf("\nhave:\n%s\nwant:\n%s", have, want)
+	}
+}
+
+func TestSetLogger(t *testing.T) {
+	log := new(testLogger)
+	SetLogger(log)
+	defer SetLogger(nil)
+
+	es := &EventStream{Paths: []string{t.TempDir()}, Backend: PollBackend, PollInterval: time.Hour}
+	if err := es.Start(); err != nil {
+		t.Fatal(err)
+	}
+	es.Stop()
+	if !strings.Contains(log.String(), "fsevents: stopped") {
+		t.Errorf("nothing logged with the package Logger:\n%s", log)
+	}
+
+	// The Logger of the stream takes precedence.
+	own := new(testLogger)
+	es.Logger = own
+	before := log.String()
+	if err := es.Start(); err != nil {
+		t.Fatal(err)
+	}
+	es.Stop()
+	if log.String() != before || own.String() == "" {
+		t.Errorf("\npackage Logger:\n%s\nstream Logger:\n%s", log, own)
+	}
+}
+
+func TestLoggerDropped(t *testing.T) {
+	log := new(testLogger)
+	es := &EventStream{Paths: []string{"/a"}, Logger: log}
+	q := newOverflowQueue(DropOverflow, 1, 0, es.Paths)
+	q.configure(es.Paths, es.logger())
+	q.push([]Event{{Path: "/a/1", ID: 1}, {Flags: HistoryDone, ID: 2}}, nil)
+	q.push([]Event{{Path: "/a/2", ID: 3}}, nil)
+
+	want := "WARN fsevents: dropped events paths=[/a] device=0 batches=2 events=2 event_id=3"
+	if have := log.String(); have != want {
+		t.Errorf("\nhave: %s\nwant: %s", have, want)
+	}
+}
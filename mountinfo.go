@@ -0,0 +1,162 @@
+//go:build linux
+
+package fsevents
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+// mountInfo is a single line of /proc/self/mountinfo.
+type mountInfo struct {
+	id, parent int
+	dev        uint64 // st_dev of the files on the mount
+	root       string // directory of the filesystem mounted at mountPoint
+	mountPoint string
+	fsType     string
+	source     string
+}
+
+// readMountInfo reads the mount table of the current process.
+func readMountInfo() ([]mountInfo, error) {
+	fp, err := os.Open("/proc/self/mountinfo")
+	if err != nil {
+		return nil, err
+	}
+	defer fp.Close()
+	return parseMountInfo(fp)
+}
+
+// parseMountInfo parses the format of /proc/[pid]/mountinfo, as described in
+// proc(5).
+func parseMountInfo(r io.Reader) ([]mountInfo, error) {
+	var (
+		mounts []mountInfo
+		scan   = bufio.NewScanner(r)
+		no     int
+	)
+	for scan.Scan() {
+		no++
+		line := scan.Text()
+		if line == "" {
+			continue
+		}
+
+		// 36 35 98:0 /mnt1 /mnt2 rw,noatime master:1 - ext3 /dev/root rw
+		fields := strings.Fields(line)
+		sep := -1
+		for i := 6; i < len(fields); i++ {
+			if fields[i] == "-" {
+				sep = i
+				break
+			}
+		}
+		if sep == -1 || len(fields) < sep+3 {
+			return nil, fmt.Errorf("fsevents: mountinfo line %d: malformed: %q", no, line)
+		}
+
+		var (
+			m   mountInfo
+			err error
+		)
+		if m.id, err = strconv.Atoi(fields[0]); err != nil {
+			return nil, fmt.Errorf("fsevents: mountinfo line %d: %w", no, err)
+		}
+		if m.parent, err = strconv.Atoi(fields[1]); err != nil {
+			return nil, fmt.Errorf("fsevents: mountinfo line %d: %w", no, err)
+		}
+		var major, minor uint32
+		if _, err := fmt.Sscanf(fields[2], "%d:%d", &major, &minor); err != nil {
+			return nil, fmt.Errorf("fsevents: mountinfo line %d: device %q: %w", no, fields[2], err)
+		}
+		m.dev = unix.Mkdev(major, minor)
+		m.root = unescapeMountInfo(fields[3])
+		m.mountPoint = unescapeMountInfo(fields[4])
+		m.fsType = fields[sep+1]
+		m.source = unescapeMountInfo(fields[sep+2])
+		mounts = append(mounts, m)
+	}
+	return mounts, scan.Err()
+}
+
+// unescapeMountInfo replaces the octal escapes the kernel uses for spaces,
+// tabs, newlines and backslashes in mountinfo.
+func unescapeMountInfo(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+	b := make([]byte, 0, len(s))
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+3 < len(s) {
+			if n, err := strconv.ParseUint(s[i+1:i+4], 8, 8); err == nil {
+				b = append(b, byte(n))
+				i += 3
+				continue
+			}
+		}
+		b = append(b, s[i])
+	}
+	return string(b)
+}
+
+// deviceMountPoint returns where the root of the filesystem on dev is
+// mounted.
+func deviceMountPoint(mounts []mountInfo, dev uint64) (string, error) {
+	for _, m := range mounts {
+		if m.dev == dev && m.root == "/" {
+			return m.mountPoint, nil
+		}
+	}
+	return "", fmt.Errorf("fsevents: no mount for the root of device %d:%d",
+		unix.Major(dev), unix.Minor(dev))
+}
+
+// deviceMounts are the mounts of a single filesystem, which map the paths
+// relative to its root to absolute paths.
+type deviceMounts []mountInfo
+
+// newDeviceMounts returns the mounts of the filesystem on dev.
+func newDeviceMounts(mounts []mountInfo, dev uint64) (deviceMounts, error) {
+	var d deviceMounts
+	for _, m := range mounts {
+		if m.dev == dev {
+			d = append(d, m)
+		}
+	}
+	if len(d) == 0 {
+		return nil, fmt.Errorf("fsevents: no mount for device %d:%d", unix.Major(dev), unix.Minor(dev))
+	}
+	return d, nil
+}
+
+// abs returns where rel, a path relative to the root of the filesystem, is
+// mounted: below the mount of its root if there's one, or otherwise below the
+// mount of the deepest directory that contains it, such as the bind mounts of
+// containers. It returns false if rel isn't mounted anywhere.
+func (d deviceMounts) abs(rel string) (string, bool) {
+	p := filepath.Join("/", rel)
+	var best *mountInfo
+	for i, m := range d {
+		if m.root == "/" {
+			best = &d[i]
+			break
+		}
+		if (p == m.root || strings.HasPrefix(p, m.root+"/")) && (best == nil || len(m.root) > len(best.root)) {
+			best = &d[i]
+		}
+	}
+	if best == nil {
+		return rel, false
+	}
+	if best.root == "/" {
+		return filepath.Join(best.mountPoint, p), true
+	}
+	return filepath.Join(best.mountPoint, strings.TrimPrefix(p, best.root)), true
+}
@@ -0,0 +1,240 @@
+//go:build linux
+
+package fsevents
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+const mountInfoFixture = `22 1 253:1 / / rw,relatime shared:1 - ext4 /dev/vda1 rw
+23 22 0:21 / /proc rw,nosuid,nodev,noexec,relatime shared:5 - proc proc rw
+24 22 0:40 / /tmp rw,nosuid,nodev shared:6 - tmpfs tmpfs rw,size=8G
+25 22 253:1 /srv/data /mnt/with\040space rw,relatime shared:1 - ext4 /dev/vda1 rw
+26 22 8:17 / /data rw,relatime shared:7 master:3 - xfs /dev/sdb1 rw,attr2
+`
+
+func TestParseMountInfo(t *testing.T) {
+	mounts, err := parseMountInfo(strings.NewReader(mountInfoFixture))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(mounts) != 5 {
+		t.Fatalf("have %d mounts, want 5", len(mounts))
+	}
+
+	m := mounts[3]
+	if m.id != 25 || m.parent != 22 || m.dev != unix.Mkdev(253, 1) ||
+		m.root != "/srv/data" || m.mountPoint != "/mnt/with space" ||
+		m.fsType != "ext4" || m.source != "/dev/vda1" {
+		t.Errorf("wrong mount: %#v", m)
+	}
+
+	tests := []struct {
+		dev  uint64
+		want string
+	}{
+		{unix.Mkdev(253, 1), "/"},
+		{unix.Mkdev(0, 40), "/tmp"},
+		{unix.Mkdev(8, 17), "/data"},
+		{unix.Mkdev(8, 18), ""},
+	}
+	for _, tt := range tests {
+		have, err := deviceMountPoint(mounts, tt.dev)
+		if tt.want == "" {
+			if err == nil {
+				t.Errorf("%d: expected an error, have %q", tt.dev, have)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if have != tt.want {
+			t.Errorf("%d: have %q, want %q", tt.dev, have, tt.want)
+		}
+	}
+
+	if _, err := parseMountInfo(strings.NewReader("22 1 253:1 / /\n")); err == nil {
+		t.Error("expected an error for a malformed line")
+	}
+}
+
+func TestDeviceUUID(t *testing.T) {
+	mounts, err := parseMountInfo(strings.NewReader(mountInfoFixture))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	byUUID := t.TempDir()
+	rdevs := map[string]uint64{
+		"8d2a6c38-2b0e-4a43-9d4f-2f2c2a8c0d11": unix.Mkdev(253, 1),
+		"7E3A-1F20":                            unix.Mkdev(8, 33),
+	}
+	for name := range rdevs {
+		touch(t, byUUID, name)
+	}
+	d := &devices{
+		mounts: mounts,
+		byUUID: byUUID,
+		rdev: func(path string) (uint64, error) {
+			return rdevs[filepath.Base(path)], nil
+		},
+		fsid: func(mountPoint string) ([2]int32, error) {
+			if mountPoint == "/data" {
+				return [2]int32{0x811, -2}, nil
+			}
+			return [2]int32{}, nil
+		},
+	}
+
+	tests := []struct {
+		dev  uint64
+		want string
+	}{
+		{unix.Mkdev(253, 1), "8d2a6c38-2b0e-4a43-9d4f-2f2c2a8c0d11"},
+		{unix.Mkdev(8, 17), "00000811-fffffffe"}, // From statfs.
+		{unix.Mkdev(0, 40), ""},                  // tmpfs has no fsid.
+		{unix.Mkdev(8, 33), ""},                  // Not mounted.
+	}
+	for _, tt := range tests {
+		have, err := d.uuid(tt.dev)
+		if tt.want == "" {
+			if err == nil {
+				t.Errorf("%d: expected an error, have %q", tt.dev, have)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if have != tt.want {
+			t.Errorf("%d: have %q, want %q", tt.dev, have, tt.want)
+		}
+	}
+
+	// Without by-uuid everything comes from statfs.
+	d.byUUID = filepath.Join(byUUID, "missing")
+	if _, err := d.uuid(unix.Mkdev(253, 1)); err == nil {
+		t.Error("expected an error without by-uuid and fsid")
+	}
+}
+
+func TestDeviceIDForPath(t *testing.T) {
+	tmp := t.TempDir()
+	var st unix.Stat_t
+	if err := unix.Stat(tmp, &st); err != nil {
+		t.Fatal(err)
+	}
+
+	dev, err := DeviceIDForPath(tmp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if uint64(dev) != st.Dev {
+		t.Errorf("have device %d, want %d", dev, st.Dev)
+	}
+
+	mountPoint, err := DeviceMountPoint(dev)
+	if err != nil {
+		t.Skipf("no mount for the root of %s: %s", tmp, err)
+	}
+	var mst unix.Stat_t
+	if err := unix.Stat(mountPoint, &mst); err != nil {
+		t.Fatal(err)
+	}
+	if mst.Dev != st.Dev {
+		t.Errorf("mount point %q is on device %d, want %d", mountPoint, mst.Dev, st.Dev)
+	}
+}
+
+func TestDeviceMounts(t *testing.T) {
+	tests := []struct {
+		fixture string
+		dev     uint64
+		rel     string
+		want    string // "" if it isn't mounted
+	}{
+		// The mount of the root of the filesystem is preferred.
+		{"host.txt", unix.Mkdev(253, 1), "srv/data/file", "/srv/data/file"},
+		{"host.txt", unix.Mkdev(253, 1), "/etc/passwd", "/etc/passwd"},
+		{"host.txt", unix.Mkdev(8, 17), "exports/a", "/data/exports/a"},
+		{"host.txt", unix.Mkdev(0, 40), "", "/tmp"},
+
+		// Containers only have bind mounts of some directories.
+		{"container.txt", unix.Mkdev(253, 1), "home/dev/project/main.go", "/workspace/main.go"},
+		{"container.txt", unix.Mkdev(253, 1), "home/dev/project", "/workspace"},
+		{"container.txt", unix.Mkdev(253, 1), "home/dev/project/cache/x", "/workspace/.cache/x"},
+		{"container.txt", unix.Mkdev(253, 1), "var/lib/docker/containers/3f2a/resolv.conf", "/etc/resolv.conf"},
+		{"container.txt", unix.Mkdev(253, 1), "home/dev/my files/a b", "/files/a b"},
+		{"container.txt", unix.Mkdev(253, 1), "home/dev/projects", ""},
+		{"container.txt", unix.Mkdev(253, 1), "etc/passwd", ""},
+		{"container.txt", unix.Mkdev(0, 52), "usr/bin", "/usr/bin"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.fixture+":"+tt.rel, func(t *testing.T) {
+			fp, err := os.Open(filepath.Join("testdata", "mountinfo", tt.fixture))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer fp.Close()
+			mounts, err := parseMountInfo(fp)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			d, err := newDeviceMounts(mounts, tt.dev)
+			if err != nil {
+				t.Fatal(err)
+			}
+			have, ok := d.abs(tt.rel)
+			if tt.want == "" {
+				if ok || have != tt.rel {
+					t.Errorf("have %q, %t; want it unchanged", have, ok)
+				}
+				return
+			}
+			if !ok || have != tt.want {
+				t.Errorf("have %q, %t; want %q", have, ok, tt.want)
+			}
+		})
+	}
+
+	if _, err := newDeviceMounts(nil, unix.Mkdev(8, 1)); err == nil {
+		t.Error("expected an error for a device that isn't mounted")
+	}
+}
+
+func TestAbsolutePaths(t *testing.T) {
+	fp, err := os.Open(filepath.Join("testdata", "mountinfo", "container.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fp.Close()
+	mounts, err := parseMountInfo(fp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d, err := newDeviceMounts(mounts, unix.Mkdev(253, 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	es := &EventStream{Events: make(chan []Event, 1), absPath: d.abs}
+	es.emit([]Event{
+		{Path: "home/dev/project/a", Flags: ItemCreated, ID: 1},
+		{Path: "tmp/b", Flags: ItemCreated, ID: 2},
+	}, nil)
+	have := Events(<-es.Events)
+	want := Events{
+		{Path: "/workspace/a", Flags: ItemCreated},
+		{Path: "tmp/b", Flags: ItemCreated},
+	}
+	if have.String() != want.String() {
+		t.Errorf("\nhave:\n%s\nwant:\n%s", indent(have), indent(want))
+	}
+}
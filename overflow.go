@@ -0,0 +1,334 @@
+package fsevents
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Overflow selects what an EventStream does when the consumer doesn't keep up
+// with the events.
+type Overflow int
+
+const (
+	// BlockOverflow waits until the consumer receives each batch. This
+	// stalls the stream: FSEvents and the kernel hold the events back
+	// meanwhile, and drop them once they run out of room.
+	BlockOverflow Overflow = iota
+
+	// TimeoutOverflow buffers up to OverflowBuffer batches. Once the
+	// buffer is full, it waits up to OverflowTimeout for the consumer
+	// to make room, and then drops the batches like DropOverflow.
+	TimeoutOverflow
+
+	// DropOverflow buffers up to OverflowBuffer batches. Once the buffer
+	// is full, the buffered batches and the new one are replaced with a
+	// single batch with a MustScanSubDirs|UserDropped event for each of
+	// the paths they happened under, like FSEvents does when it drops
+	// events. That batch keeps absorbing the overflowing batches until
+	// the consumer catches up.
+	DropOverflow
+)
+
+func (o Overflow) String() string {
+	switch o {
+	case BlockOverflow:
+		return "BlockOverflow"
+	case TimeoutOverflow:
+		return "TimeoutOverflow"
+	case DropOverflow:
+		return "DropOverflow"
+	default:
+		return fmt.Sprintf("Overflow(%d)", int(o))
+	}
+}
+
+const (
+	defaultOverflowBuffer  = 16
+	defaultOverflowTimeout = time.Second
+)
+
+// overflowQueue holds the batches of a stream between its backend and Events,
+// and applies the Overflow policy when the consumer doesn't keep up. A single
+// goroutine pushes batches, while the queue delivers them from its own.
+type overflowQueue struct {
+	overflow Overflow
+	size     int
+	timeout  time.Duration
+	clock    Clock
+	dropped  func(batches, events int) // called after dropping, if not nil
+
+	mu      sync.Mutex
+	roots   []watchRoot
+	log     Logger // for the dropped batches, if not nil
+	batches [][]Event
+	sending bool            // a batch taken from batches is being delivered
+	acks    []chan struct{} // closed once everything is delivered
+
+	ready  chan struct{} // signaled when a batch is pushed
+	space  chan struct{} // signaled when a batch is taken
+	done   chan struct{} // closed by stop
+	exited chan struct{} // closed when the queue stops delivering
+}
+
+// watchRoot is one of the paths of a stream, with the forms it may have in
+// the paths of events.
+type watchRoot struct {
+	path  string
+	forms []string
+}
+
+func newWatchRoots(paths []string) []watchRoot {
+	var roots []watchRoot
+	for _, p := range paths {
+		r := watchRoot{path: p, forms: []string{filepath.Clean(p)}}
+		if abs, err := filepath.Abs(p); err == nil {
+			r.forms = append(r.forms, abs)
+			if real, err := filepath.EvalSymlinks(abs); err == nil {
+				r.forms = append(r.forms, real)
+			}
+		}
+		roots = append(roots, r)
+	}
+	return roots
+}
+
+// contains reports whether path is the root or below it.
+func (r watchRoot) contains(path string) bool {
+	for _, f := range r.forms {
+		if path == f || strings.HasPrefix(path, strings.TrimSuffix(f, "/")+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// newOverflowQueue returns a queue for the stream watching paths. A size or
+// timeout of zero selects the default.
+func newOverflowQueue(overflow Overflow, size int, timeout time.Duration, paths []string) *overflowQueue {
+	if size <= 0 {
+		size = defaultOverflowBuffer
+	}
+	if timeout <= 0 {
+		timeout = defaultOverflowTimeout
+	}
+	q := &overflowQueue{
+		overflow: overflow,
+		size:     size,
+		timeout:  timeout,
+		ready:    make(chan struct{}, 1),
+		space:    make(chan struct{}, 1),
+		done:     make(chan struct{}),
+		exited:   make(chan struct{}),
+		roots:    newWatchRoots(paths),
+	}
+	return q
+}
+
+// configure sets the paths of the stream, and the Logger for the dropped
+// batches.
+func (q *overflowQueue) configure(paths []string, log Logger) {
+	roots := newWatchRoots(paths)
+	q.mu.Lock()
+	q.roots = roots
+	q.log = log
+	q.mu.Unlock()
+}
+
+func (q *overflowQueue) newTimer(d time.Duration) Timer {
+	if q.clock == nil {
+		return realClock{}.NewTimer(d)
+	}
+	return q.clock.NewTimer(d)
+}
+
+// start delivers the batches with emit, which returns false if done was closed
+// before the batch was delivered, or if the stream can't take more batches.
+func (q *overflowQueue) start(emit func(events []Event, done <-chan struct{}) bool) {
+	go q.run(emit)
+}
+
+// stop stops delivering. The batches that weren't delivered are dropped.
+func (q *overflowQueue) stop() {
+	close(q.done)
+	<-q.exited
+}
+
+func (q *overflowQueue) run(emit func([]Event, <-chan struct{}) bool) {
+	defer close(q.exited)
+
+	for {
+		q.mu.Lock()
+		if len(q.batches) == 0 {
+			q.mu.Unlock()
+			select {
+			case <-q.ready:
+				continue
+			case <-q.done:
+				return
+			}
+		}
+		// The batch being delivered is no longer in the queue, so it
+		// can't be dropped anymore.
+		batch := q.batches[0]
+		q.batches[0] = nil
+		q.batches = q.batches[1:]
+		q.sending = true
+		q.mu.Unlock()
+		signal(q.space)
+
+		if !emit(batch, q.done) {
+			return
+		}
+
+		q.mu.Lock()
+		q.sending = false
+		if len(q.batches) == 0 {
+			for _, ack := range q.acks {
+				close(ack)
+			}
+			q.acks = nil
+		}
+		q.mu.Unlock()
+	}
+}
+
+// wait waits until the batches queued so far have been delivered, or the
+// queue is stopped.
+func (q *overflowQueue) wait() {
+	q.mu.Lock()
+	if len(q.batches) == 0 && !q.sending {
+		q.mu.Unlock()
+		return
+	}
+	ack := make(chan struct{})
+	q.acks = append(q.acks, ack)
+	q.mu.Unlock()
+
+	select {
+	case <-ack:
+	case <-q.exited:
+	}
+}
+
+// push queues a batch. If the queue is full, it applies the policy. It
+// returns false if done was closed while waiting for room.
+func (q *overflowQueue) push(events []Event, done <-chan struct{}) bool {
+	if q.add(events) {
+		return true
+	}
+
+	if q.overflow == TimeoutOverflow {
+		t := q.newTimer(q.timeout)
+		defer t.Stop()
+	wait:
+		for {
+			select {
+			case <-q.space:
+				if q.add(events) {
+					return true
+				}
+			case <-t.C():
+				break wait
+			case <-done:
+				return false
+			}
+		}
+	}
+
+	q.mu.Lock()
+	batches, n, id := q.drop(events)
+	log := q.log
+	q.mu.Unlock()
+	signal(q.ready)
+	if q.dropped != nil {
+		q.dropped(batches, n)
+	}
+	if log != nil {
+		log.Warn("fsevents: dropped events", "batches", batches, "events", n, "event_id", id)
+	}
+	return true
+}
+
+// add queues the batch if there's room.
+func (q *overflowQueue) add(events []Event) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if len(q.batches) >= q.size {
+		return false
+	}
+	q.batches = append(q.batches, events)
+	signal(q.ready)
+	return true
+}
+
+// drop replaces the queued batches and events with a batch that has a
+// MustScanSubDirs|UserDropped event for each of the roots they happened under.
+// The events about the stream itself rather than a path, such as HistoryDone,
+// are kept. It returns how many batches and events were dropped, and the
+// highest event ID among them: the UserDropped events, such as those of a
+// previous drop, are coalesced without being counted, and so are the batches
+// with nothing else dropped. q.mu must be held.
+func (q *overflowQueue) drop(events []Event) (batches, n int, maxID uint64) {
+	var (
+		dropped = make([]bool, len(q.roots))
+		batch   []Event
+	)
+	for _, b := range append(q.batches, events) {
+		counted := n
+		for _, e := range b {
+			if e.ID > maxID {
+				maxID = e.ID
+			}
+			if e.Flags&(HistoryDone|EventIDsWrapped|RootChanged|Mount|Unmount) != 0 {
+				batch = append(batch, e)
+				continue
+			}
+			q.mark(dropped, e.Path)
+			if e.Flags&UserDropped == 0 {
+				n++
+			}
+		}
+		if n > counted {
+			batches++
+		}
+	}
+	for i, r := range q.roots {
+		if dropped[i] {
+			batch = append(batch, Event{Path: r.path, Flags: MustScanSubDirs | UserDropped, ID: maxID})
+		}
+	}
+	q.batches = q.batches[:0]
+	if len(batch) > 0 {
+		q.batches = append(q.batches, batch)
+	}
+	return batches, n, maxID
+}
+
+// mark marks the roots path is under, or all of them if it isn't under any.
+func (q *overflowQueue) mark(dropped []bool, path string) {
+	found := false
+	for i, r := range q.roots {
+		if r.contains(path) {
+			dropped[i] = true
+			found = true
+		}
+	}
+	if !found {
+		for i := range dropped {
+			dropped[i] = true
+		}
+	}
+}
+
+// signal wakes up the receiver of c, a channel with a buffer of one, without
+// blocking.
+func signal(c chan struct{}) {
+	select {
+	case c <- struct{}{}:
+	default:
+	}
+}
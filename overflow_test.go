@@ -0,0 +1,207 @@
+package fsevents
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+// formatBatches formats batches as "path:flags:id" events, with batches
+// separated by " | ".
+func formatBatches(batches [][]Event) string {
+	var out []string
+	for _, b := range batches {
+		var events []string
+		for _, e := range b {
+			events = append(events, fmt.Sprintf("%s:%#x:%d", e.Path, uint32(e.Flags), e.ID))
+		}
+		out = append(out, strings.Join(events, " "))
+	}
+	return strings.Join(out, " | ")
+}
+
+func TestOverflowDrop(t *testing.T) {
+	q := newOverflowQueue(DropOverflow, 2, 0, []string{"/a", "/b/"})
+	done := make(chan struct{})
+
+	for _, b := range [][]Event{
+		{{Path: "/a/1", Flags: ItemCreated, ID: 1}},
+		{{Path: "/b/2", Flags: ItemCreated, ID: 2}},
+		// Full: all three are dropped.
+		{{Path: "/a/3", Flags: ItemCreated, ID: 3}},
+	} {
+		if !q.push(b, done) {
+			t.Fatal("push failed")
+		}
+	}
+	const dropped = MustScanSubDirs | UserDropped
+	want := formatBatches([][]Event{
+		{{Path: "/a", Flags: dropped, ID: 3}, {Path: "/b/", Flags: dropped, ID: 3}},
+	})
+	if have := formatBatches(q.batches); have != want {
+		t.Fatalf("\nhave: %s\nwant: %s", have, want)
+	}
+
+	for _, b := range [][]Event{
+		{{Path: "/a/4", Flags: ItemCreated, ID: 4}},
+		// Full again: coalesced with the events dropped before, while
+		// HistoryDone is kept.
+		{{Flags: HistoryDone, ID: 5}, {Path: "/b/6", Flags: ItemCreated, ID: 6}},
+		{{Path: "/a/7", Flags: ItemCreated, ID: 7}},
+	} {
+		if !q.push(b, done) {
+			t.Fatal("push failed")
+		}
+	}
+	want = formatBatches([][]Event{
+		{{Flags: HistoryDone, ID: 5}, {Path: "/a", Flags: dropped, ID: 6}, {Path: "/b/", Flags: dropped, ID: 6}},
+		{{Path: "/a/7", Flags: ItemCreated, ID: 7}},
+	})
+	if have := formatBatches(q.batches); have != want {
+		t.Fatalf("\nhave: %s\nwant: %s", have, want)
+	}
+
+	// The batches are delivered in order.
+	var (
+		out  = make(chan []Event, 4)
+		last []Event
+	)
+	q.start(func(b []Event, _ <-chan struct{}) bool {
+		out <- b
+		last = b
+		return true
+	})
+	q.wait()
+	q.stop()
+	close(out)
+	var have [][]Event
+	for b := range out {
+		have = append(have, b)
+	}
+	if have := formatBatches(have); have != want {
+		t.Fatalf("\nhave: %s\nwant: %s", have, want)
+	}
+	if len(last) != 1 || last[0].ID != 7 {
+		t.Errorf("last delivered batch is %v", last)
+	}
+}
+
+func TestOverflowDropTwice(t *testing.T) {
+	q := newOverflowQueue(DropOverflow, 1, 0, []string{"/a", "/b"})
+	var counts []string
+	q.dropped = func(batches, events int) {
+		counts = append(counts, fmt.Sprintf("%d/%d", batches, events))
+	}
+
+	// The batch left by the first overflow isn't counted again by the
+	// second one, only the batch that didn't fit.
+	q.push([]Event{{Path: "/a/1", ID: 1}, {Path: "/a/2", ID: 2}}, nil)
+	q.push([]Event{{Path: "/b/3", ID: 3}}, nil)
+	q.push([]Event{{Path: "/a/4", ID: 4}}, nil)
+	if have, want := strings.Join(counts, " "), "2/3 1/1"; have != want {
+		t.Errorf("dropped %s, want %s", have, want)
+	}
+
+	const dropped = MustScanSubDirs | UserDropped
+	want := formatBatches([][]Event{
+		{{Path: "/a", Flags: dropped, ID: 4}, {Path: "/b", Flags: dropped, ID: 4}},
+	})
+	if have := formatBatches(q.batches); have != want {
+		t.Fatalf("\nhave: %s\nwant: %s", have, want)
+	}
+}
+
+func TestOverflowDropUnknownPath(t *testing.T) {
+	q := newOverflowQueue(DropOverflow, 1, 0, []string{"/a", "/b"})
+	q.push([]Event{{Path: "/a/1", ID: 1}}, nil)
+	q.push([]Event{{Path: "/elsewhere", ID: 2}}, nil)
+
+	// Events that aren't under any of the paths affect all of them.
+	const dropped = MustScanSubDirs | UserDropped
+	want := formatBatches([][]Event{
+		{{Path: "/a", Flags: dropped, ID: 2}, {Path: "/b", Flags: dropped, ID: 2}},
+	})
+	if have := formatBatches(q.batches); have != want {
+		t.Fatalf("\nhave: %s\nwant: %s", have, want)
+	}
+}
+
+func TestOverflowTimeout(t *testing.T) {
+	clock := newVirtualClock()
+	newQueue := func() *overflowQueue {
+		q := newOverflowQueue(TimeoutOverflow, 1, time.Second, []string{"/a"})
+		q.clock = clock
+		return q
+	}
+	// waitTimer waits until push is waiting with a timer.
+	waitTimer := func() {
+		t.Helper()
+		for i := 0; ; i++ {
+			clock.mu.Lock()
+			n := len(clock.timers)
+			clock.mu.Unlock()
+			if n > 0 {
+				return
+			}
+			if i == 1000 {
+				t.Fatal("push isn't waiting")
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	t.Run("room", func(t *testing.T) {
+		q := newQueue()
+		q.push([]Event{{Path: "/a/1", ID: 1}}, nil)
+
+		pushed := make(chan bool)
+		go func() { pushed <- q.push([]Event{{Path: "/a/2", ID: 2}}, nil) }()
+		waitTimer()
+
+		// The consumer makes room before the timeout.
+		out := make(chan []Event)
+		q.start(func(b []Event, done <-chan struct{}) bool {
+			_, ok := sendBatch(out, b, done)
+			return ok
+		})
+		defer q.stop()
+		if b := <-out; b[0].ID != 1 {
+			t.Fatalf("received %v", b)
+		}
+		<-pushed
+		if b := <-out; b[0].ID != 2 {
+			t.Fatalf("received %v, want the second batch", b)
+		}
+	})
+
+	t.Run("timeout", func(t *testing.T) {
+		q := newQueue()
+		q.push([]Event{{Path: "/a/1", ID: 1}}, nil)
+
+		pushed := make(chan bool)
+		go func() { pushed <- q.push([]Event{{Path: "/a/2", ID: 2}}, nil) }()
+		waitTimer()
+		clock.Advance(time.Second)
+		<-pushed
+
+		want := formatBatches([][]Event{{{Path: "/a", Flags: MustScanSubDirs | UserDropped, ID: 2}}})
+		if have := formatBatches(q.batches); have != want {
+			t.Fatalf("\nhave: %s\nwant: %s", have, want)
+		}
+	})
+
+	t.Run("done", func(t *testing.T) {
+		q := newQueue()
+		q.push([]Event{{Path: "/a/1", ID: 1}}, nil)
+
+		done := make(chan struct{})
+		pushed := make(chan bool)
+		go func() { pushed <- q.push([]Event{{Path: "/a/2", ID: 2}}, done) }()
+		waitTimer()
+		close(done)
+		if <-pushed {
+			t.Error("push succeeded after done was closed")
+		}
+	})
+}
@@ -0,0 +1,294 @@
+//go:build darwin || linux
+
+package fsevents
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"syscall"
+	"time"
+)
+
+// defaultPollInterval is the PollInterval used if it's not set.
+const defaultPollInterval = time.Second
+
+// poller is the PollBackend: it walks the paths every PollInterval and turns
+// the differences with the previous walk into events.
+type poller struct {
+	es       *EventStream
+	interval time.Duration
+	roots    []string
+
+	flushes  chan chan struct{} // scan and deliver right away
+	done     chan struct{}      // closed by stop
+	draining chan struct{}      // closed by drain
+	exited   chan struct{}      // closed when the poller exits
+
+	// Only accessed by the poller after start.
+	snap    map[string]pollStat
+	batcher *Batcher
+}
+
+// pollStat is what the poller remembers of every path.
+type pollStat struct {
+	mode  fs.FileMode
+	size  int64
+	mtime time.Time
+	ctime time.Time
+	ino   uint64
+	nlink uint64
+	uid   uint32
+	gid   uint32
+}
+
+func newPoller(es *EventStream) (backend, error) {
+	if es.Device != 0 {
+		return nil, errors.New("fsevents: device streams are not supported by PollBackend")
+	}
+	interval := es.PollInterval
+	if interval <= 0 {
+		interval = defaultPollInterval
+	}
+	return &poller{
+		es:       es,
+		interval: interval,
+		flushes:  make(chan chan struct{}, 16),
+		done:     make(chan struct{}),
+		draining: make(chan struct{}),
+		exited:   make(chan struct{}),
+		batcher:  NewBatcher(es.Latency, es.Flags),
+	}, nil
+}
+
+func (p *poller) start() error {
+	for _, path := range p.es.Paths {
+		if err := p.addRoot(path); err != nil {
+			return err
+		}
+	}
+	p.snap = p.walk()
+
+	go p.run()
+	return nil
+}
+
+// addRoot resolves one of EventStream.Paths the way inotify does, so that a
+// missing path is an error and a symlink's target is walked.
+func (p *poller) addRoot(path string) error {
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return newPathError("abs", path, err)
+	}
+	path, err = filepath.EvalSymlinks(path)
+	if err != nil {
+		return newPathError("evalsymlinks", path, err)
+	}
+	if _, err := os.Lstat(path); err != nil {
+		return newPathError("lstat", path, err)
+	}
+	p.roots = append(p.roots, path)
+	return nil
+}
+
+func (p *poller) flush(sync bool) {
+	ack := make(chan struct{})
+	select {
+	case p.flushes <- ack:
+	case <-p.exited:
+		return
+	}
+	if sync {
+		select {
+		case <-ack:
+		case <-p.exited:
+		}
+	}
+}
+
+func (p *poller) stop() {
+	close(p.done)
+	<-p.exited
+}
+
+func (p *poller) drain() {
+	close(p.draining)
+	<-p.exited
+}
+
+func (p *poller) describe() Description {
+	return Description{
+		Text: fmt.Sprintf("poller: paths = %q, interval = %s, latency = %s, flags = %#x",
+			p.roots, p.interval, p.es.Latency, uint32(p.es.Flags)),
+		Paths: append([]string(nil), p.roots...),
+	}
+}
+
+// run polls until the poller is stopped, and delivers the events according
+// to Latency and NoDefer.
+func (p *poller) run() {
+	defer close(p.exited)
+
+	tick := time.NewTicker(p.interval)
+	defer tick.Stop()
+
+	var (
+		batcher = p.batcher
+		timer   = batchTimer{b: batcher}
+	)
+	defer timer.stop()
+
+	for {
+		select {
+		case <-p.draining:
+			// Deliver what's waiting for Latency, without scanning
+			// again.
+			if events := batcher.Flush(); len(events) > 0 {
+				p.es.send(events, p.done)
+			}
+			return
+		default:
+		}
+
+		var (
+			events []Event
+			ack    chan struct{}
+		)
+		select {
+		case <-p.done:
+			return
+		case <-p.draining:
+			continue
+		case <-timer.C():
+			events = batcher.Due()
+		case <-tick.C:
+			events = batcher.Add(p.scan())
+		case ack = <-p.flushes:
+			events = append(batcher.Add(p.scan()), batcher.Flush()...)
+		}
+
+		if len(events) > 0 && !p.es.send(events, p.done) {
+			return
+		}
+		if ack != nil {
+			close(ack)
+		}
+		timer.reset()
+	}
+}
+
+// scan walks the paths and returns the events for all changes since the
+// previous scan.
+func (p *poller) scan() []Event {
+	var (
+		events []Event
+		snap   = p.walk()
+	)
+	for path, cur := range snap {
+		prev, ok := p.snap[path]
+		if !ok {
+			events = append(events, Event{Path: path, Flags: ItemCreated | cur.item(), Inode: cur.ino})
+			continue
+		}
+		if flags := pollDiff(prev, cur); flags != 0 {
+			events = append(events, Event{Path: path, Flags: flags | cur.item(), Inode: cur.ino})
+		}
+	}
+	for path, prev := range p.snap {
+		if _, ok := snap[path]; !ok {
+			events = append(events, Event{Path: path, Flags: ItemRemoved | prev.item(), Inode: prev.ino})
+		}
+	}
+	p.snap = snap
+
+	// Report parents before their children, and removals last.
+	sort.Slice(events, func(i, j int) bool {
+		ri, rj := events[i].Flags&ItemRemoved != 0, events[j].Flags&ItemRemoved != 0
+		if ri != rj {
+			return rj
+		}
+		if ri {
+			return events[i].Path > events[j].Path
+		}
+		return events[i].Path < events[j].Path
+	})
+	return events
+}
+
+// pollDiff returns the flags for the changes between two stats of a path.
+func pollDiff(prev, cur pollStat) EventFlags {
+	if prev.ino != cur.ino || prev.mode.Type() != cur.mode.Type() {
+		// Replaced by something else.
+		return ItemRemoved | ItemCreated
+	}
+
+	var flags EventFlags
+	// The times of directories change with their entries, which are
+	// reported themselves.
+	if !cur.mode.IsDir() {
+		if prev.size != cur.size || !prev.mtime.Equal(cur.mtime) {
+			flags |= ItemModified
+		}
+		if flags == 0 && !prev.ctime.Equal(cur.ctime) {
+			flags |= ItemInodeMetaMod
+		}
+	}
+	if prev.mode != cur.mode || prev.uid != cur.uid || prev.gid != cur.gid {
+		flags |= ItemInodeMetaMod
+	}
+	return flags
+}
+
+// item returns the ItemIs* flags for the item.
+func (s pollStat) item() EventFlags {
+	switch {
+	case s.mode.IsDir():
+		return ItemIsDir
+	case s.mode&fs.ModeSymlink != 0:
+		return ItemIsSymlink
+	case s.nlink > 1:
+		return ItemIsFile | ItemIsHardlink
+	default:
+		return ItemIsFile
+	}
+}
+
+// walk stats everything below the roots.
+func (p *poller) walk() map[string]pollStat {
+	snap := make(map[string]pollStat, len(p.snap))
+	for _, root := range p.roots {
+		filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				// Report what can be read; entries that disappear or
+				// can't be read show up as removed.
+				return nil
+			}
+			fi, err := d.Info()
+			if err != nil {
+				return nil
+			}
+			snap[path] = newPollStat(fi)
+			return nil
+		})
+	}
+	return snap
+}
+
+func newPollStat(fi os.FileInfo) pollStat {
+	s := pollStat{
+		mode:  fi.Mode(),
+		size:  fi.Size(),
+		mtime: fi.ModTime(),
+	}
+	if st, ok := fi.Sys().(*syscall.Stat_t); ok {
+		s.ino = st.Ino
+		s.nlink = uint64(st.Nlink)
+		s.uid = st.Uid
+		s.gid = st.Gid
+		s.ctime = statCtime(st)
+	}
+	return s
+}
@@ -0,0 +1,337 @@
+//go:build darwin || linux
+
+package fsevents
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestPoll(t *testing.T) {
+	tmp := tempDir(t)
+	touch(t, tmp, "file")
+	touch(t, tmp, "remove")
+
+	es := &EventStream{
+		Paths:        []string{tmp},
+		Flags:        FileEvents,
+		Backend:      PollBackend,
+		PollInterval: time.Hour,
+		Events:       make(chan []Event, 16),
+	}
+	if err := es.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer es.Stop()
+
+	mkdir(t, tmp, "dir")
+	echoAppend(t, "data", tmp, "dir", "new")
+	echoAppend(t, "data", tmp, "file")
+	chmod(t, 0o600, tmp, "file")
+	rm(t, tmp, "remove")
+	symlink(t, "file", tmp, "link")
+
+	es.Flush(true)
+
+	var have Events
+	for len(es.Events) > 0 {
+		have = append(have, <-es.Events...)
+	}
+	want := Events{
+		{Path: join(tmp, "dir"), Flags: ItemCreated | ItemIsDir},
+		{Path: join(tmp, "dir", "new"), Flags: ItemCreated | ItemIsFile},
+		{Path: join(tmp, "file"), Flags: ItemModified | ItemInodeMetaMod | ItemIsFile},
+		{Path: join(tmp, "link"), Flags: ItemCreated | ItemIsSymlink},
+		{Path: join(tmp, "remove"), Flags: ItemRemoved | ItemIsFile},
+	}
+	if have.String() != want.String() {
+		t.Errorf("\nhave:\n%s\nwant:\n%s", indent(have), indent(want))
+	}
+}
+
+func TestPollPaths(t *testing.T) {
+	tmp := tempDir(t)
+
+	es := &EventStream{Paths: []string{join(tmp, "missing")}, Backend: PollBackend}
+	err := es.Start()
+	if err == nil {
+		es.Stop()
+		t.Fatal("started watching a missing path")
+	}
+	var pe *PathError
+	if !errors.Is(err, ErrPathNotFound) || !errors.As(err, &pe) {
+		t.Errorf("Start returned %#v, want a PathError matching ErrPathNotFound", err)
+	}
+
+	// A symlink is resolved, and its target walked.
+	mkdir(t, tmp, "dir")
+	symlink(t, join(tmp, "dir"), tmp, "link")
+	es = &EventStream{
+		Paths:        []string{join(tmp, "link")},
+		Flags:        FileEvents,
+		Backend:      PollBackend,
+		PollInterval: time.Hour,
+		Events:       make(chan []Event, 16),
+	}
+	if err := es.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer es.Stop()
+	if d := es.Describe(); len(d.Paths) != 1 || d.Paths[0] != join(tmp, "dir") {
+		t.Errorf("Paths is %q", d.Paths)
+	}
+
+	touch(t, tmp, "dir", "file")
+	es.Flush(true)
+	have := Events(<-es.Events)
+	want := Events{{Path: join(tmp, "dir", "file"), Flags: ItemCreated | ItemIsFile}}
+	if have.String() != want.String() {
+		t.Errorf("\nhave:\n%s\nwant:\n%s", indent(have), indent(want))
+	}
+}
+
+func TestPollInode(t *testing.T) {
+	tmp := tempDir(t)
+	touch(t, tmp, "remove")
+	fi, err := os.Lstat(join(tmp, "remove"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	removed := fi.Sys().(*syscall.Stat_t).Ino
+
+	es := &EventStream{
+		Paths:        []string{tmp},
+		Flags:        FileEvents,
+		Backend:      PollBackend,
+		PollInterval: time.Hour,
+		Events:       make(chan []Event, 16),
+	}
+	if err := es.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer es.Stop()
+
+	touch(t, tmp, "file")
+	if err := os.Link(join(tmp, "file"), join(tmp, "link")); err != nil {
+		t.Fatal(err)
+	}
+	if fi, err = os.Lstat(join(tmp, "file")); err != nil {
+		t.Fatal(err)
+	}
+	ino := fi.Sys().(*syscall.Stat_t).Ino
+	rm(t, tmp, "remove")
+	es.Flush(true)
+
+	var have []Event
+	for len(es.Events) > 0 {
+		have = append(have, <-es.Events...)
+	}
+	// The poller still has the inode of removed items.
+	want := []Event{
+		{Path: join(tmp, "file"), Flags: ItemCreated | ItemIsFile | ItemIsHardlink, Inode: ino},
+		{Path: join(tmp, "link"), Flags: ItemCreated | ItemIsFile | ItemIsHardlink, Inode: ino},
+		{Path: join(tmp, "remove"), Flags: ItemRemoved | ItemIsFile, Inode: removed},
+	}
+	if len(have) != len(want) {
+		t.Fatalf("received %+v", have)
+	}
+	for i := range want {
+		if have[i].Path != want[i].Path || have[i].Flags != want[i].Flags || have[i].Inode != want[i].Inode {
+			t.Errorf("event %d is %+v, want %+v", i, have[i], want[i])
+		}
+	}
+}
+
+func TestPollReplaced(t *testing.T) {
+	tmp := tempDir(t)
+	touch(t, tmp, "file")
+	touch(t, tmp, "other")
+
+	es := &EventStream{
+		Paths:        []string{join(tmp, "file")},
+		Flags:        FileEvents,
+		Backend:      PollBackend,
+		PollInterval: time.Hour,
+		Events:       make(chan []Event, 16),
+	}
+	if err := es.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer es.Stop()
+
+	mv(t, join(tmp, "other"), tmp, "file")
+	es.Flush(true)
+
+	have := Events(<-es.Events)
+	want := Events{{Path: join(tmp, "file"), Flags: ItemCreated | ItemRemoved | ItemIsFile}}
+	if have.String() != want.String() {
+		t.Errorf("\nhave:\n%s\nwant:\n%s", indent(have), indent(want))
+	}
+}
+
+func TestPollLatency(t *testing.T) {
+	const latency = 500 * time.Millisecond
+
+	tests := []struct {
+		name    string
+		flags   CreateFlags
+		batches int
+	}{
+		// Everything within the latency of the first change is a
+		// single group.
+		{"trailing", FileEvents, 1},
+		// The first change is delivered right away, and the rest after
+		// the latency.
+		{"leading", FileEvents | NoDefer, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tmp := tempDir(t)
+
+			es := &EventStream{
+				Paths:        []string{tmp},
+				Flags:        tt.flags,
+				Latency:      latency,
+				Backend:      PollBackend,
+				PollInterval: 10 * time.Millisecond,
+				Events:       make(chan []Event, 16),
+			}
+			if err := es.Start(); err != nil {
+				t.Fatal(err)
+			}
+			defer es.Stop()
+
+			start := time.Now()
+			for i := 0; i < 3; i++ {
+				if err := os.WriteFile(filepath.Join(tmp, string(rune('a'+i))), nil, 0o644); err != nil {
+					t.Fatal(err)
+				}
+				time.Sleep(50 * time.Millisecond)
+			}
+
+			var (
+				batches [][]Event
+				n       int
+			)
+			for n < 3 {
+				select {
+				case msg := <-es.Events:
+					batches = append(batches, msg)
+					n += len(msg)
+				case <-time.After(5 * time.Second):
+					t.Fatalf("timed out; have %d batches", len(batches))
+				}
+			}
+			if len(batches) != tt.batches {
+				t.Errorf("have %d batches, want %d: %v", len(batches), tt.batches, batches)
+			}
+			if took := time.Since(start); took < latency {
+				t.Errorf("delivered after %s; latency is %s", took, latency)
+			}
+		})
+	}
+}
+
+func TestPollDevice(t *testing.T) {
+	es := &EventStream{Paths: []string{"/"}, Device: 1, Backend: PollBackend}
+	if err := es.Start(); err == nil {
+		es.Stop()
+		t.Fatal("device streams should not be supported")
+	}
+}
+
+func TestPollDropOverflow(t *testing.T) {
+	tmp := tempDir(t)
+
+	es := &EventStream{
+		Paths:          []string{tmp},
+		Flags:          FileEvents,
+		Backend:        PollBackend,
+		PollInterval:   time.Hour,
+		Overflow:       DropOverflow,
+		OverflowBuffer: 1,
+	}
+	if err := es.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer es.Stop()
+
+	// Nothing receives the batches, so they overflow. The backend is
+	// flushed directly, since es.Flush(true) waits for the batches to be
+	// received.
+	for _, name := range []string{"a", "b", "c"} {
+		touch(t, tmp, name)
+		es.backend.flush(true)
+	}
+
+	// The first batch may have been taken before the buffer overflowed.
+	for i := 0; i < 2; i++ {
+		select {
+		case b := <-es.Events:
+			have := Events(b)
+			if len(have) == 1 && have[0].Flags == MustScanSubDirs|UserDropped && have[0].Path == tmp {
+				return
+			}
+			if i == 1 || len(have) != 1 || have[0].Path != join(tmp, "a") {
+				t.Fatalf("\nhave:\n%s", indent(have))
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("timeout")
+		}
+	}
+}
+
+func TestPollFlatEvents(t *testing.T) {
+	tmp := tempDir(t)
+
+	es := &EventStream{
+		Paths:        []string{tmp},
+		Flags:        FileEvents,
+		Backend:      PollBackend,
+		PollInterval: time.Hour,
+		FlatEvents:   make(chan Event),
+	}
+	if err := es.Start(); err != nil {
+		t.Fatal(err)
+	}
+
+	touch(t, tmp, "a")
+	touch(t, tmp, "b")
+	go func() {
+		es.Flush(true)
+		es.Stop()
+	}()
+
+	var have Events
+	for e := range es.FlatEvents {
+		have = append(have, e)
+	}
+	want := Events{
+		{Path: join(tmp, "a"), Flags: ItemCreated | ItemIsFile},
+		{Path: join(tmp, "b"), Flags: ItemCreated | ItemIsFile},
+	}
+	if have.String() != want.String() {
+		t.Errorf("\nhave:\n%s\nwant:\n%s", indent(have), indent(want))
+	}
+	if id := es.LatestEventID(); id != have[1].ID {
+		t.Errorf("LatestEventID is %d, want %d", id, have[1].ID)
+	}
+	if len(es.Events) != 0 {
+		t.Error("events were sent on Events")
+	}
+
+	// Start replaces the closed channel.
+	if err := es.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer es.Stop()
+	touch(t, tmp, "c")
+	go es.Flush(false)
+	if e := <-es.FlatEvents; e.Path != join(tmp, "c") {
+		t.Errorf("received %v", e)
+	}
+}
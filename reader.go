@@ -0,0 +1,217 @@
+//go:build linux
+
+package fsevents
+
+import (
+	"fmt"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// fdReader delivers the events read from a notification file descriptor to
+// an EventStream. It's shared by the inotify and fanotify backends, which
+// provide start and the conversion of what was read.
+type fdReader struct {
+	es *EventStream
+
+	fd   int    // notification file descriptor
+	epfd int    // epoll instance waiting on fd and pipe[0]
+	pipe [2]int // wakes the reader for flushes and stop
+
+	done     chan struct{} // closed by stop
+	draining chan struct{} // closed by drain
+	exited   chan struct{} // closed when the reader exits
+
+	mu     sync.Mutex
+	acks   []chan struct{} // pending synchronous flushes
+	closed bool            // the file descriptors were closed by stop
+
+	// Only accessed by the reader after start.
+	batcher *Batcher
+	errs    []error // reported while converting, sent before the events
+}
+
+func newFDReader(es *EventStream) fdReader {
+	return fdReader{
+		es:       es,
+		fd:       -1,
+		epfd:     -1,
+		pipe:     [2]int{-1, -1},
+		done:     make(chan struct{}),
+		draining: make(chan struct{}),
+		exited:   make(chan struct{}),
+		batcher:  NewBatcher(es.Latency, es.Flags),
+	}
+}
+
+// open prepares reading from the non-blocking file descriptor fd. All file
+// descriptors are closed if it fails.
+func (r *fdReader) open(fd int) error {
+	r.fd = fd
+
+	var err error
+	if err = syscall.Pipe2(r.pipe[:], syscall.O_NONBLOCK|syscall.O_CLOEXEC); err != nil {
+		r.close()
+		return fmt.Errorf("fsevents: pipe2: %w", err)
+	}
+	r.epfd, err = syscall.EpollCreate1(syscall.EPOLL_CLOEXEC)
+	if err != nil {
+		r.close()
+		return fmt.Errorf("fsevents: epoll_create1: %w", err)
+	}
+	for _, fd := range []int{r.fd, r.pipe[0]} {
+		ev := syscall.EpollEvent{Events: syscall.EPOLLIN, Fd: int32(fd)}
+		if err := syscall.EpollCtl(r.epfd, syscall.EPOLL_CTL_ADD, fd, &ev); err != nil {
+			r.close()
+			return fmt.Errorf("fsevents: epoll_ctl: %w", err)
+		}
+	}
+	return nil
+}
+
+// close releases all file descriptors.
+func (r *fdReader) close() {
+	for _, fd := range []int{r.fd, r.epfd, r.pipe[0], r.pipe[1]} {
+		if fd != -1 {
+			syscall.Close(fd)
+		}
+	}
+}
+
+func (r *fdReader) flush(sync bool) {
+	var ack chan struct{}
+	r.mu.Lock()
+	if r.closed {
+		// Stopped by another goroutine.
+		r.mu.Unlock()
+		return
+	}
+	if sync {
+		ack = make(chan struct{})
+		r.acks = append(r.acks, ack)
+	}
+	r.wake()
+	r.mu.Unlock()
+
+	if sync {
+		select {
+		case <-ack:
+		case <-r.exited:
+		}
+	}
+}
+
+func (r *fdReader) stop() {
+	close(r.done)
+	r.wake()
+	<-r.exited
+
+	// A concurrent flush must not wake a reused file descriptor.
+	r.mu.Lock()
+	r.closed = true
+	r.close()
+	r.mu.Unlock()
+}
+
+func (r *fdReader) drain() {
+	r.mu.Lock()
+	if !r.closed {
+		close(r.draining)
+		r.wake()
+	}
+	r.mu.Unlock()
+	<-r.exited
+}
+
+// wake interrupts the reader's epoll_wait.
+func (r *fdReader) wake() {
+	// The pipe being full just means the reader is already woken up.
+	syscall.Write(r.pipe[1], []byte{0})
+}
+
+// report records an error that occurred while converting what was read, to be
+// sent on Errors.
+func (r *fdReader) report(err error) {
+	r.errs = append(r.errs, err)
+}
+
+// run reads from the file descriptor into buf whenever it's readable, and
+// delivers what convert makes of it in batches according to Latency and
+// NoDefer. drained is called after everything that was pending has been read.
+func (r *fdReader) run(buf []byte, convert func([]byte) []Event, drained func()) {
+	defer close(r.exited)
+
+	var (
+		pipe   [64]byte
+		events = make([]syscall.EpollEvent, 2)
+	)
+	for {
+		// Wake up when the pending events are due.
+		timeout := -1
+		if d, ok := r.batcher.Next(); ok {
+			timeout = int((d + time.Millisecond - 1) / time.Millisecond)
+		}
+		if _, err := syscall.EpollWait(r.epfd, events, timeout); err != nil && err != syscall.EINTR {
+			r.es.sendError(fmt.Errorf("fsevents: epoll_wait: %w; no more events are delivered", err), r.done)
+			return
+		}
+		select {
+		case <-r.done:
+			return
+		case <-r.draining:
+			// Deliver what's waiting for Latency, without reading
+			// more.
+			if batch := r.batcher.Flush(); len(batch) > 0 {
+				r.es.send(batch, r.done)
+			}
+			return
+		default:
+		}
+
+		// Collect the flushes before reading, so that everything that
+		// happened before Flush was called gets delivered.
+		for {
+			if _, err := syscall.Read(r.pipe[0], pipe[:]); err != nil {
+				break
+			}
+		}
+		r.mu.Lock()
+		acks := r.acks
+		r.acks = nil
+		r.mu.Unlock()
+
+		var batch []Event
+		for {
+			n, err := syscall.Read(r.fd, buf)
+			if err == syscall.EINTR {
+				continue
+			}
+			if n <= 0 || err != nil {
+				break
+			}
+			batch = append(batch, convert(buf[:n])...)
+		}
+		drained()
+
+		for _, err := range r.errs {
+			if !r.es.sendError(err, r.done) {
+				return
+			}
+		}
+		r.errs = nil
+
+		batch = r.batcher.Add(batch)
+		if len(acks) > 0 {
+			batch = append(batch, r.batcher.Flush()...)
+		} else if batch == nil {
+			batch = r.batcher.Due()
+		}
+		if len(batch) > 0 && !r.es.send(batch, r.done) {
+			return
+		}
+		for _, ack := range acks {
+			close(ack)
+		}
+	}
+}
@@ -0,0 +1,152 @@
+//go:build darwin || linux
+
+package fsevents
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Reconfigure changes the Paths, Flags and Latency of a running stream without
+// stopping it. A stream with the new configuration is started while the old
+// one keeps delivering, resuming from the last event delivered. Once it's
+// started, the old one stops reading events, delivers the ones it already read,
+// and is stopped; meanwhile the events of the new one are held back. Then the
+// events of the new one that the old one already delivered, going by their
+// IDs, are skipped. Consumers keep receiving from the same Events channel,
+// without a gap or duplicates. Reconfigure doesn't wait for the old stream to
+// be drained, so it can be called while receiving from Events.
+//
+// If the new stream can't be started, the error is returned and the stream
+// keeps running with the old configuration. If the stream isn't started,
+// Reconfigure only sets the fields.
+//
+// Without a history, such as on Linux without a Journal, the events that
+// occur while the streams overlap are only deduplicated by ID if the new
+// stream got them first, and the events for new paths start when it's
+// started.
+func (es *EventStream) Reconfigure(paths []string, flags CreateFlags, latency time.Duration) error {
+	es.mu.Lock()
+	defer es.mu.Unlock()
+
+	if es.backend == nil {
+		es.Paths, es.Flags, es.Latency = paths, flags, latency
+		return nil
+	}
+
+	h := &handoff{
+		parent: es,
+		roots:  newWatchRoots(es.Paths),
+		ready:  make(chan struct{}),
+	}
+	next := &EventStream{
+		Paths:        paths,
+		Flags:        flags,
+		Latency:      latency,
+		Resume:       true,
+		EventID:      es.LatestEventID(),
+		Device:       es.Device,
+		Backend:      es.Backend,
+		PollInterval: es.PollInterval,
+		Journal:      es.Journal,
+		Errors:       es.Errors,
+		Logger:       es.Logger,
+		handoff:      h,
+	}
+	if err := next.start(); err != nil {
+		return err
+	}
+
+	es.logger().Debug("fsevents: reconfiguring", "event_id", next.EventID)
+	d := &drain{backend: es.backend, done: make(chan struct{})}
+	es.backend, next.backend = next.backend, nil
+	es.Paths, es.Flags, es.Latency = paths, flags, latency
+	es.paths.Store(paths)
+	atomic.StoreUint32(&es.flags, uint32(flags))
+	if es.queue != nil {
+		es.queue.configure(paths, es.logger())
+	}
+
+	drains := es.drains[:0]
+	for _, old := range es.drains {
+		select {
+		case <-old.done:
+		default:
+			drains = append(drains, old)
+		}
+	}
+	es.drains = append(drains, d)
+	go func() {
+		defer close(d.done)
+		// The old backend may have events that happened before the new
+		// one was started: a batch it's blocked delivering, or events
+		// waiting for Latency.
+		d.backend.drain()
+		d.stop()
+
+		// Everything the old stream delivered has been, so the new one
+		// can take over.
+		h.lastID = es.LatestEventID()
+		close(h.ready)
+	}()
+	return nil
+}
+
+// drain is a backend replaced by Reconfigure, which delivers the events it
+// already read before it's stopped.
+type drain struct {
+	backend backend
+	once    sync.Once
+	done    chan struct{} // closed once it's stopped and the handoff is done
+}
+
+// stop stops the backend, which ends the drain if it's still delivering.
+func (d *drain) stop() {
+	d.once.Do(d.backend.stop)
+}
+
+// handoff holds back the events of a stream started by Reconfigure until the
+// stream it replaces is stopped, and then delivers them to the original
+// stream.
+type handoff struct {
+	parent *EventStream
+	roots  []watchRoot // paths of the replaced stream
+
+	ready  chan struct{} // closed once the replaced stream is stopped
+	lastID uint64        // ID of the last event it delivered
+}
+
+// deliver delivers events on the original stream, without the ones the
+// replaced stream already delivered, or the HistoryDone of resuming. It
+// returns false if done was closed first.
+func (h *handoff) deliver(events []Event, done <-chan struct{}) bool {
+	select {
+	case <-h.ready:
+	case <-done:
+		return false
+	}
+
+	kept := events[:0:0]
+	for _, e := range events {
+		if e.Flags&HistoryDone != 0 || (e.ID != 0 && e.ID <= h.lastID && h.delivered(e.Path)) {
+			continue
+		}
+		kept = append(kept, e)
+	}
+	if len(kept) == 0 {
+		return true
+	}
+	return h.parent.deliver(kept, done)
+}
+
+// delivered reports whether the replaced stream watched path, and so delivered
+// its events up to lastID.
+func (h *handoff) delivered(path string) bool {
+	for _, r := range h.roots {
+		if r.contains(path) {
+			return true
+		}
+	}
+	return false
+}
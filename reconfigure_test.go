@@ -0,0 +1,164 @@
+//go:build darwin || linux
+
+package fsevents
+
+import (
+	"testing"
+	"time"
+)
+
+func TestReconfigure(t *testing.T) {
+	tmp := tempDir(t)
+	mkdir(t, tmp, "a")
+	mkdir(t, tmp, "b")
+
+	es := &EventStream{
+		Paths:        []string{join(tmp, "a")},
+		Flags:        FileEvents,
+		Backend:      PollBackend,
+		PollInterval: time.Hour,
+		Events:       make(chan []Event, 16),
+	}
+	if err := es.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer es.Stop()
+
+	touch(t, tmp, "a", "1")
+	es.Flush(true)
+	first := <-es.Events
+
+	events := es.Events
+	if err := es.Reconfigure([]string{join(tmp, "a"), join(tmp, "b")}, FileEvents|NoDefer, time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	if es.Events != events {
+		t.Error("Events was replaced")
+	}
+	if len(es.Paths) != 2 || es.Flags != FileEvents|NoDefer || es.Latency != time.Millisecond {
+		t.Errorf("not reconfigured: %q %#x %s", es.Paths, uint32(es.Flags), es.Latency)
+	}
+
+	touch(t, tmp, "a", "2")
+	touch(t, tmp, "b", "1")
+	es.Flush(true)
+
+	var have Events
+	for len(es.Events) > 0 {
+		have = append(have, <-es.Events...)
+	}
+	want := Events{
+		{Path: join(tmp, "a", "2"), Flags: ItemCreated | ItemIsFile},
+		{Path: join(tmp, "b", "1"), Flags: ItemCreated | ItemIsFile},
+	}
+	if have.String() != want.String() {
+		t.Errorf("\nhave:\n%s\nwant:\n%s", indent(have), indent(want))
+	}
+	for _, e := range have {
+		if e.ID <= first[0].ID {
+			t.Errorf("event ID %d isn't after %d", e.ID, first[0].ID)
+		}
+	}
+}
+
+func TestReconfigurePending(t *testing.T) {
+	tmp := tempDir(t)
+	mkdir(t, tmp, "a")
+	mkdir(t, tmp, "b")
+
+	es := &EventStream{
+		Paths:        []string{join(tmp, "a")},
+		Flags:        FileEvents,
+		Backend:      PollBackend,
+		PollInterval: time.Hour,
+	}
+	if err := es.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer es.Stop()
+
+	// Reconfigure doesn't wait for the batch the old stream has pending
+	// to be received, which it may be blocked delivering, and it's
+	// delivered before the events of the new stream.
+	touch(t, tmp, "a", "1")
+	es.Flush(false)
+	time.Sleep(10 * time.Millisecond)
+	if err := es.Reconfigure([]string{join(tmp, "a"), join(tmp, "b")}, FileEvents, 0); err != nil {
+		t.Fatal(err)
+	}
+	touch(t, tmp, "b", "1")
+	es.Flush(false)
+
+	var have Events
+	for len(have) < 2 {
+		select {
+		case msg := <-es.Events:
+			have = append(have, msg...)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out; have %v", have)
+		}
+	}
+	want := Events{
+		{Path: join(tmp, "a", "1"), Flags: ItemCreated | ItemIsFile},
+		{Path: join(tmp, "b", "1"), Flags: ItemCreated | ItemIsFile},
+	}
+	if have.String() != want.String() {
+		t.Errorf("\nhave:\n%s\nwant:\n%s", indent(have), indent(want))
+	}
+}
+
+func TestReconfigureStopped(t *testing.T) {
+	es := &EventStream{Paths: []string{"/a"}}
+	if err := es.Reconfigure([]string{"/b"}, FileEvents, time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if len(es.Paths) != 1 || es.Paths[0] != "/b" || es.Flags != FileEvents || es.Latency != time.Second {
+		t.Errorf("not reconfigured: %q %#x %s", es.Paths, uint32(es.Flags), es.Latency)
+	}
+	if es.Events != nil {
+		t.Error("stream was started")
+	}
+}
+
+func TestHandoff(t *testing.T) {
+	es := &EventStream{Events: make(chan []Event, 1)}
+	h := &handoff{
+		parent: es,
+		roots:  newWatchRoots([]string{"/a"}),
+		ready:  make(chan struct{}),
+		lastID: 5,
+	}
+
+	// Nothing is delivered until the replaced stream is stopped.
+	done := make(chan struct{})
+	close(done)
+	if h.deliver([]Event{{Path: "/a/0", ID: 6}}, done) {
+		t.Fatal("delivered before the handoff")
+	}
+
+	close(h.ready)
+	h.deliver([]Event{
+		{Path: "/a/1", ID: 4},            // delivered by the replaced stream
+		{Path: "/b/1", ID: 5},            // not watched by the replaced stream
+		{Flags: HistoryDone, ID: 5},      // from resuming
+		{Path: "/a/2", ID: 6},            // after the replaced stream stopped
+		{Path: "/a", Flags: RootChanged}, // no ID
+	}, nil)
+	have := formatBatches([][]Event{<-es.Events})
+	want := formatBatches([][]Event{{
+		{Path: "/b/1", ID: 5},
+		{Path: "/a/2", ID: 6},
+		{Path: "/a", Flags: RootChanged},
+	}})
+	if have != want {
+		t.Errorf("\nhave: %s\nwant: %s", have, want)
+	}
+	if es.LatestEventID() != 6 {
+		t.Errorf("LatestEventID is %d", es.LatestEventID())
+	}
+
+	// Batches that are entirely skipped aren't delivered.
+	if !h.deliver([]Event{{Path: "/a/3", ID: 3}}, nil) || len(es.Events) != 0 {
+		t.Error("skipped batch was delivered")
+	}
+}
@@ -0,0 +1,159 @@
+//go:build darwin || linux
+
+package fsevents
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// Recorder wraps an EventStream and writes every batch it delivers, with the
+// time it arrived and the configuration of the stream, so it can be replayed
+// later with a ReplayStream. The batches are passed on unchanged on the
+// Recorder's own Events channel:
+//
+//	f, err := os.Create("events.rec")
+//	...
+//	r := fsevents.NewRecorder(es, f)
+//	r.Start()
+//	for msg := range r.Events {
+//		...
+//	}
+type Recorder struct {
+	// Stream holds the recorded stream.
+	Stream *EventStream
+
+	// Events holds the channel on which the recorded batches are passed
+	// on. It's initialized by Recorder.Start if nil, and replaced by a new
+	// channel with the same capacity if it was closed. It's closed once the
+	// recorder is stopped, or the stream is.
+	Events chan []Event
+
+	enc    *json.Encoder
+	header bool // the header has been written
+
+	mu     sync.Mutex
+	done   chan struct{} // closed by Stop
+	exited chan struct{} // closed when the recorder exits
+	closed bool          // Events was closed by run
+
+	errMu sync.Mutex
+	err   error // first write error
+}
+
+// NewRecorder returns a Recorder that writes the batches delivered by es to w.
+func NewRecorder(es *EventStream, w io.Writer) *Recorder {
+	return &Recorder{Stream: es, enc: json.NewEncoder(w)}
+}
+
+// Start starts both the stream and the recording. The configuration of the
+// stream is written the first time. This creates Events if it's not already a
+// valid channel.
+func (r *Recorder) Start() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.done != nil {
+		return ErrAlreadyStarted
+	}
+	es := r.Stream
+	if !r.header {
+		err := r.enc.Encode(recordHeader{
+			Version: recordVersion,
+			Paths:   es.Paths,
+			Flags:   uint32(es.Flags),
+			Latency: int64(es.Latency),
+			Device:  es.Device,
+		})
+		if err != nil {
+			return fmt.Errorf("fsevents: writing recording header: %w", err)
+		}
+		r.header = true
+	}
+	if err := es.Start(); err != nil {
+		return err
+	}
+	if r.Events == nil {
+		r.Events = make(chan []Event)
+	} else if r.closed {
+		r.Events = make(chan []Event, cap(r.Events))
+		r.closed = false
+	}
+	r.done = make(chan struct{})
+	r.exited = make(chan struct{})
+
+	go r.run(es.Events, r.Events, r.done, r.exited)
+	return nil
+}
+
+// Stop stops the stream and the recording, and closes r.Events.
+func (r *Recorder) Stop() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.done == nil {
+		return
+	}
+	r.Stream.Stop()
+	close(r.done)
+	<-r.exited
+	r.done, r.exited = nil, nil
+	r.closed = true
+}
+
+// Err returns the first error writing the recording. Batches are still passed
+// on after an error, but no longer recorded.
+func (r *Recorder) Err() error {
+	r.errMu.Lock()
+	defer r.errMu.Unlock()
+	return r.err
+}
+
+// run records and passes on the batches to out until the recorder is stopped,
+// and closes out.
+func (r *Recorder) run(events <-chan []Event, out chan []Event, done, exited chan struct{}) {
+	defer close(exited)
+	defer close(out)
+
+	var failed bool
+	for {
+		var (
+			msg []Event
+			ok  bool
+		)
+		select {
+		case msg, ok = <-events:
+			if !ok {
+				// The stream was stopped.
+				return
+			}
+		case <-done:
+			return
+		}
+
+		// Write before passing the batch on, as the receiver may modify it.
+		if !failed {
+			if err := r.enc.Encode(recordBatch{Time: time.Now(), Events: newRecordEvents(msg)}); err != nil {
+				failed = true
+				r.setErr(fmt.Errorf("fsevents: writing recording: %w", err))
+			}
+		}
+
+		select {
+		case out <- msg:
+		case <-done:
+			return
+		}
+	}
+}
+
+func (r *Recorder) setErr(err error) {
+	r.errMu.Lock()
+	defer r.errMu.Unlock()
+	if r.err == nil {
+		r.err = err
+	}
+}
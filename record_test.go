@@ -0,0 +1,120 @@
+//go:build darwin || linux
+
+package fsevents
+
+import (
+	"bytes"
+	"io"
+	"testing"
+	"time"
+)
+
+func TestRecorder(t *testing.T) {
+	tmp := tempDir(t)
+
+	es := &EventStream{
+		Paths:        []string{tmp},
+		Flags:        FileEvents,
+		Latency:      time.Second,
+		Backend:      PollBackend,
+		PollInterval: time.Hour,
+		Events:       make(chan []Event, 16),
+	}
+	var buf bytes.Buffer
+	r := NewRecorder(es, &buf)
+	r.Events = make(chan []Event, 16)
+	if err := r.Start(); err != nil {
+		t.Fatal(err)
+	}
+
+	touch(t, tmp, "file")
+	es.Flush(true)
+	rm(t, tmp, "file")
+	es.Flush(true)
+
+	var have []Event
+	for len(have) < 2 {
+		select {
+		case msg := <-r.Events:
+			have = append(have, msg...)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out; have %v", have)
+		}
+	}
+	r.Stop()
+	if err := r.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	rec, err := ReadRecording(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rec.Paths) != 1 || rec.Paths[0] != tmp || rec.Flags != FileEvents || rec.Latency != time.Second {
+		t.Errorf("wrong configuration: %+v", rec)
+	}
+	if len(rec.Batches) != 2 {
+		t.Fatalf("have %d batches, want 2", len(rec.Batches))
+	}
+	if rec.Batches[1].Time.Before(rec.Batches[0].Time) {
+		t.Errorf("batch times out of order: %v", rec.Batches)
+	}
+	for i, b := range rec.Batches {
+		if len(b.Events) != 1 || b.Events[0] != have[i] {
+			t.Errorf("batch %d: have %v, want %v", i, b.Events, have[i])
+		}
+	}
+}
+
+func TestRecorderStop(t *testing.T) {
+	tmp := tempDir(t)
+	es := &EventStream{
+		Paths:        []string{tmp},
+		Flags:        FileEvents,
+		Backend:      PollBackend,
+		PollInterval: time.Hour,
+	}
+	r := NewRecorder(es, io.Discard)
+	r.Events = make(chan []Event, 16)
+	if err := r.Start(); err != nil {
+		t.Fatal(err)
+	}
+	touch(t, tmp, "file")
+	es.Flush(true)
+	for start := time.Now(); len(r.Events) == 0; time.Sleep(time.Millisecond) {
+		if time.Since(start) > 5*time.Second {
+			t.Fatal("timed out")
+		}
+	}
+	r.Stop()
+
+	// The batches passed on before Stop are still received.
+	var have Events
+	for msg := range r.Events {
+		have = append(have, msg...)
+	}
+	have.TrimPrefix(tmp)
+	want := Events{{Path: "/file", Flags: ItemCreated | ItemIsFile}}
+	if have.String() != want.String() {
+		t.Errorf("\nhave:\n%s\nwant:\n%s", indent(have), indent(want))
+	}
+
+	// Start replaces the closed channel, which is closed again when the
+	// stream is stopped.
+	if err := r.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer r.Stop()
+	es.Stop()
+	done := make(chan struct{})
+	go func() {
+		for range r.Events {
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Events not closed")
+	}
+}
@@ -0,0 +1,104 @@
+package fsevents
+
+import (
+	"os"
+	"sort"
+)
+
+// Rename is the rename of an item, paired from its ItemRenamed events by
+// PairRenames. FSEvents reports a rename as an event for the old path and one
+// for the new path; when only one of them is watched, there's a single event.
+type Rename struct {
+	// From and To hold the old and the new path. From is empty if the
+	// item was moved into the watched paths from elsewhere, and To if it
+	// was moved out of them.
+	From, To string
+
+	// Flags holds the flags of the events, combined.
+	Flags EventFlags
+
+	// ID holds the ID of the last event of the rename.
+	ID uint64
+}
+
+// MovedIn reports whether the item was moved into the watched paths, so only
+// its new path is known.
+func (r Rename) MovedIn() bool {
+	return r.From == ""
+}
+
+// MovedOut reports whether the item was moved out of the watched paths, so
+// only its old path is known.
+func (r Rename) MovedOut() bool {
+	return r.To == ""
+}
+
+// itemTypes are the flags for the type of an item.
+const itemTypes = ItemIsFile | ItemIsDir | ItemIsSymlink
+
+// PairRenames pairs the ItemRenamed events in events, in the order of their
+// IDs. A renamed event and the next one are a rename from the first path to
+// the second if they're for the same type of item, with the same Inode if
+// both have one, the first path no longer exists after the rename, and the
+// second does. The other renamed events
+// were moved into the watched paths if the path exists, or out of them
+// otherwise.
+//
+// Whether a path exists after a rename is known from the later events for it
+// in events: an item created at the path shows it was moved away, and an item
+// modified or removed shows it was moved there. Without any, it's checked
+// with Lstat, so events should be paired when they're received.
+func PairRenames(events []Event) []Rename {
+	return pairRenames(events, func(path string) bool {
+		_, err := os.Lstat(path)
+		return err == nil
+	})
+}
+
+func pairRenames(events []Event, exists func(path string) bool) []Rename {
+	sorted := make([]Event, len(events))
+	copy(sorted, events)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].ID < sorted[j].ID })
+
+	var renamed []int
+	for i, e := range sorted {
+		if e.Flags&ItemRenamed != 0 {
+			renamed = append(renamed, i)
+		}
+	}
+
+	// existsAfter reports whether the path of sorted[i] exists after it.
+	existsAfter := func(i int) bool {
+		for _, e := range sorted[i+1:] {
+			if e.Path != sorted[i].Path || e.Flags&ItemRenamed != 0 {
+				continue
+			}
+			return e.Flags&ItemCreated == 0
+		}
+		return exists(sorted[i].Path)
+	}
+
+	var renames []Rename
+	for n := 0; n < len(renamed); n++ {
+		from := sorted[renamed[n]]
+		if n+1 < len(renamed) {
+			to := sorted[renamed[n+1]]
+			if from.Path != to.Path && from.Flags&itemTypes == to.Flags&itemTypes &&
+				(from.Inode == 0 || to.Inode == 0 || from.Inode == to.Inode) &&
+				!existsAfter(renamed[n]) && existsAfter(renamed[n+1]) {
+				renames = append(renames, Rename{From: from.Path, To: to.Path, Flags: from.Flags | to.Flags, ID: to.ID})
+				n++
+				continue
+			}
+		}
+
+		r := Rename{Flags: from.Flags, ID: from.ID}
+		if existsAfter(renamed[n]) {
+			r.To = from.Path
+		} else {
+			r.From = from.Path
+		}
+		renames = append(renames, r)
+	}
+	return renames
+}
@@ -0,0 +1,106 @@
+package fsevents
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func formatRenames(renames []Rename) string {
+	var out []string
+	for _, r := range renames {
+		out = append(out, fmt.Sprintf("%s>%s:%d", r.From, r.To, r.ID))
+	}
+	return strings.Join(out, " ")
+}
+
+func TestPairRenames(t *testing.T) {
+	const (
+		file = ItemRenamed | ItemIsFile
+		dir  = ItemRenamed | ItemIsDir
+	)
+	tests := []struct {
+		name   string
+		events []Event
+		exists []string
+		want   string
+	}{
+		{"pair",
+			[]Event{{Path: "/a", Flags: file, ID: 1}, {Path: "/b", Flags: file, ID: 2}},
+			[]string{"/b"},
+			"/a>/b:2"},
+		{"unsorted",
+			[]Event{{Path: "/b", Flags: file, ID: 2}, {Path: "/a", Flags: file, ID: 1}},
+			[]string{"/b"},
+			"/a>/b:2"},
+		{"moved out",
+			[]Event{{Path: "/a", Flags: file, ID: 1}},
+			nil,
+			"/a>:1"},
+		{"moved in",
+			[]Event{{Path: "/a", Flags: file, ID: 1}},
+			[]string{"/a"},
+			">/a:1"},
+		{"different types",
+			[]Event{{Path: "/a", Flags: file, ID: 1}, {Path: "/b", Flags: dir, ID: 2}},
+			[]string{"/b"},
+			"/a>:1 >/b:2"},
+		{"other inode",
+			[]Event{{Path: "/a", Flags: file, ID: 1, Inode: 10}, {Path: "/b", Flags: file, ID: 2, Inode: 11}},
+			[]string{"/b"},
+			"/a>:1 >/b:2"},
+		{"same inode",
+			[]Event{{Path: "/a", Flags: file, ID: 1, Inode: 10}, {Path: "/b", Flags: file, ID: 2, Inode: 10}},
+			[]string{"/b"},
+			"/a>/b:2"},
+		{"both exist",
+			[]Event{{Path: "/a", Flags: file, ID: 1}, {Path: "/b", Flags: file, ID: 2}},
+			[]string{"/a", "/b"},
+			">/a:1 >/b:2"},
+		{
+			// The old path was created again, so it was moved away
+			// even though it exists now.
+			"created again",
+			[]Event{
+				{Path: "/a", Flags: file, ID: 1},
+				{Path: "/b", Flags: file, ID: 2},
+				{Path: "/a", Flags: ItemCreated | ItemIsFile, ID: 3},
+			},
+			[]string{"/a", "/b"},
+			"/a>/b:2",
+		},
+		{
+			// The new path was removed later, but it was there after
+			// the rename.
+			"removed later",
+			[]Event{
+				{Path: "/a", Flags: file, ID: 1},
+				{Path: "/b", Flags: file, ID: 2},
+				{Path: "/b", Flags: ItemRemoved | ItemIsFile, ID: 3},
+			},
+			nil,
+			"/a>/b:2",
+		},
+		{"other events",
+			[]Event{
+				{Path: "/c", Flags: ItemCreated | ItemIsFile, ID: 1},
+				{Path: "/a", Flags: dir, ID: 2},
+				{Path: "/b", Flags: dir, ID: 3},
+				{Path: "/d", Flags: file, ID: 4},
+			},
+			[]string{"/b", "/c"},
+			"/a>/b:3 /d>:4"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			exists := make(map[string]bool)
+			for _, p := range tt.exists {
+				exists[p] = true
+			}
+			have := formatRenames(pairRenames(tt.events, func(path string) bool { return exists[path] }))
+			if have != tt.want {
+				t.Errorf("\nhave: %s\nwant: %s", have, tt.want)
+			}
+		})
+	}
+}
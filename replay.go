@@ -0,0 +1,279 @@
+package fsevents
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// Recording holds the batches of events delivered by an EventStream, as
+// written by a Recorder.
+type Recording struct {
+	// The configuration of the recorded stream.
+	Paths   []string
+	Flags   CreateFlags
+	Latency time.Duration
+	Device  int32
+
+	// Batches holds the batches in the order they were delivered.
+	Batches []RecordedBatch
+}
+
+// RecordedBatch is a batch of events and the time it was delivered.
+type RecordedBatch struct {
+	Time   time.Time
+	Events []Event
+}
+
+// The recording file format is JSON lines: a header with the configuration
+// of the stream, followed by one line for every batch. The fields have their
+// own types so the format doesn't change with the Go types.
+type (
+	recordHeader struct {
+		Version int      `json:"version"`
+		Paths   []string `json:"paths"`
+		Flags   uint32   `json:"flags"`
+		Latency int64    `json:"latency"` // nanoseconds
+		Device  int32    `json:"device"`
+	}
+	recordBatch struct {
+		Time   time.Time     `json:"time"`
+		Events []recordEvent `json:"events"`
+	}
+	recordEvent struct {
+		Path  string `json:"path"`
+		Flags uint32 `json:"flags"`
+		ID    uint64 `json:"id"`
+		Inode uint64 `json:"inode,omitempty"`
+	}
+)
+
+const recordVersion = 1
+
+func newRecordEvents(events []Event) []recordEvent {
+	rs := make([]recordEvent, 0, len(events))
+	for _, e := range events {
+		rs = append(rs, recordEvent{Path: e.Path, Flags: uint32(e.Flags), ID: e.ID, Inode: e.Inode})
+	}
+	return rs
+}
+
+func eventsFromRecord(rs []recordEvent) []Event {
+	events := make([]Event, 0, len(rs))
+	for _, r := range rs {
+		events = append(events, Event{Path: r.Path, Flags: EventFlags(r.Flags), ID: r.ID, Inode: r.Inode})
+	}
+	return events
+}
+
+// ReadRecording reads a recording written by a Recorder.
+func ReadRecording(r io.Reader) (*Recording, error) {
+	dec := json.NewDecoder(bufio.NewReader(r))
+
+	var h recordHeader
+	if err := dec.Decode(&h); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, fmt.Errorf("fsevents: reading recording header: %w", err)
+	}
+	if h.Version != recordVersion {
+		return nil, fmt.Errorf("fsevents: unsupported recording version %d", h.Version)
+	}
+
+	rec := &Recording{
+		Paths:   h.Paths,
+		Flags:   CreateFlags(h.Flags),
+		Latency: time.Duration(h.Latency),
+		Device:  h.Device,
+	}
+	for {
+		var b recordBatch
+		if err := dec.Decode(&b); err != nil {
+			if err == io.EOF {
+				return rec, nil
+			}
+			return nil, fmt.Errorf("fsevents: reading recording batch %d: %w", len(rec.Batches), err)
+		}
+		rec.Batches = append(rec.Batches, RecordedBatch{Time: b.Time, Events: eventsFromRecord(b.Events)})
+	}
+}
+
+// ReplayStream sends the batches of a Recording on a channel, like the
+// EventStream that was recorded did:
+//
+//	rec, err := fsevents.ReadRecording(f)
+//	...
+//	rs := &fsevents.ReplayStream{Recording: rec, Speed: 10}
+//	rs.Start()
+//	for msg := range rs.Events {
+//		...
+//	}
+type ReplayStream struct {
+	// Recording holds the batches to replay.
+	Recording *Recording
+
+	// Events holds the channel on which events will be sent. It's
+	// initialized by ReplayStream.Start if nil, and closed once all the
+	// batches have been sent or the replay is stopped.
+	Events chan []Event
+
+	// Speed scales the time between batches: 1 replays them at the
+	// original speed, 2 twice as fast, and so on. If it's zero the
+	// original speed is used, and if it's negative the batches are sent
+	// as fast as they are received.
+	Speed float64
+
+	// EventID holds the most recent event ID.
+	//
+	// NOTE: this is updated asynchronously by the replay.
+	EventID uint64
+
+	mu     sync.Mutex
+	done   chan struct{} // closed by Stop
+	exited chan struct{} // closed when the replay exits
+	closed bool          // Events was closed
+}
+
+// Start starts replaying the recording from the first batch. This creates
+// Events if it's not already a valid channel.
+func (rs *ReplayStream) Start() error {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+
+	if rs.Recording == nil {
+		return errors.New("fsevents: ReplayStream has no Recording")
+	}
+	if rs.done != nil {
+		return ErrAlreadyStarted
+	}
+	if rs.Events == nil {
+		rs.Events = make(chan []Event)
+	} else if rs.closed {
+		rs.Events = make(chan []Event, cap(rs.Events))
+		rs.closed = false
+	}
+	rs.done = make(chan struct{})
+	rs.exited = make(chan struct{})
+
+	go rs.run(rs.Recording.Batches, rs.done, rs.exited)
+	return nil
+}
+
+// Stop stops the replay, and closes rs.Events. It does nothing if the replay
+// isn't started or already finished. Once stopped or finished, the
+// ReplayStream can be started again.
+func (rs *ReplayStream) Stop() {
+	if rs.stop() {
+		rs.closeEvents()
+	}
+}
+
+// stop stops the replay and waits for it to exit, without closing Events. It
+// returns false if the replay wasn't running.
+func (rs *ReplayStream) stop() bool {
+	rs.mu.Lock()
+	if rs.done == nil {
+		rs.mu.Unlock()
+		return false
+	}
+	done, exited := rs.done, rs.exited
+	rs.done, rs.exited = nil, nil
+	close(done)
+	rs.mu.Unlock()
+
+	<-exited
+	return true
+}
+
+// closeEvents closes Events, which is replaced by the next Start.
+func (rs *ReplayStream) closeEvents() {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+
+	if !rs.closed {
+		close(rs.Events)
+		rs.closed = true
+	}
+}
+
+// end is called by run once all the batches have been sent. It closes Events
+// and clears the running state, unless the replay was stopped meanwhile.
+func (rs *ReplayStream) end(done chan struct{}) {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+
+	if rs.done != done {
+		return
+	}
+	rs.done, rs.exited = nil, nil
+	close(rs.Events)
+	rs.closed = true
+}
+
+// Wait blocks until all the batches have been sent or the replay is stopped.
+func (rs *ReplayStream) Wait() {
+	rs.mu.Lock()
+	exited := rs.exited
+	rs.mu.Unlock()
+
+	if exited != nil {
+		<-exited
+	}
+}
+
+// run sends the batches, keeping the scaled time between them.
+func (rs *ReplayStream) run(batches []RecordedBatch, done, exited chan struct{}) {
+	defer close(exited)
+
+	var (
+		start = time.Now()
+		first time.Time
+	)
+	if len(batches) > 0 {
+		first = batches[0].Time
+	}
+	for _, b := range batches {
+		if wait := rs.delay(b.Time.Sub(first)) - time.Since(start); wait > 0 {
+			t := time.NewTimer(wait)
+			select {
+			case <-t.C:
+			case <-done:
+				t.Stop()
+				return
+			}
+		}
+
+		events := make([]Event, len(b.Events))
+		copy(events, b.Events)
+		select {
+		case rs.Events <- events:
+		case <-done:
+			// Not delivered; don't skip it when resuming.
+			return
+		}
+		for _, e := range events {
+			if e.ID != 0 {
+				rs.EventID = e.ID
+			}
+		}
+	}
+	rs.end(done)
+}
+
+// delay returns the time since the start of the replay at which a batch
+// recorded d after the first one should be sent.
+func (rs *ReplayStream) delay(d time.Duration) time.Duration {
+	switch {
+	case rs.Speed < 0:
+		return 0
+	case rs.Speed == 0:
+		return d
+	default:
+		return time.Duration(float64(d) / rs.Speed)
+	}
+}
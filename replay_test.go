@@ -0,0 +1,151 @@
+package fsevents
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+const testRecording = `{"version":1,"paths":["/tmp"],"flags":16,"latency":100000000,"device":0}
+{"time":"2024-01-02T10:00:00Z","events":[{"path":"/tmp/a","flags":65792,"id":10}]}
+{"time":"2024-01-02T10:00:00.4Z","events":[{"path":"/tmp/a","flags":70656,"id":11},{"path":"/tmp/b","flags":65792,"id":12}]}
+{"time":"2024-01-02T10:00:00.8Z","events":[{"path":"/tmp/b","flags":66048,"id":13}]}
+`
+
+func TestReadRecording(t *testing.T) {
+	rec, err := ReadRecording(strings.NewReader(testRecording))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rec.Paths) != 1 || rec.Paths[0] != "/tmp" || rec.Flags != FileEvents || rec.Latency != 100*time.Millisecond {
+		t.Errorf("wrong configuration: %+v", rec)
+	}
+	if len(rec.Batches) != 3 {
+		t.Fatalf("have %d batches, want 3", len(rec.Batches))
+	}
+	want := Event{Path: "/tmp/a", Flags: ItemCreated | ItemIsFile, ID: 10}
+	if rec.Batches[0].Events[0] != want {
+		t.Errorf("have %#v, want %#v", rec.Batches[0].Events[0], want)
+	}
+
+	for _, in := range []string{"", "{}\n", testRecording + "{\n"} {
+		if _, err := ReadRecording(strings.NewReader(in)); err == nil {
+			t.Errorf("no error for %q", in)
+		}
+	}
+}
+
+func TestReplayStream(t *testing.T) {
+	rec, err := ReadRecording(strings.NewReader(testRecording))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		speed    float64
+		min, max time.Duration
+	}{
+		{-1, 0, 300 * time.Millisecond},
+		{4, 200 * time.Millisecond, 600 * time.Millisecond},
+		{0, 800 * time.Millisecond, 2 * time.Second},
+	}
+	for _, tt := range tests {
+		rs := &ReplayStream{Recording: rec, Speed: tt.speed}
+		start := time.Now()
+		if err := rs.Start(); err != nil {
+			t.Fatal(err)
+		}
+
+		var n int
+		for msg := range rs.Events {
+			if len(msg) != len(rec.Batches[n].Events) {
+				t.Errorf("speed %v: batch %d is %v", tt.speed, n, msg)
+			}
+			n++
+		}
+		took := time.Since(start)
+		rs.Stop()
+
+		if n != len(rec.Batches) {
+			t.Errorf("speed %v: have %d batches, want %d", tt.speed, n, len(rec.Batches))
+		}
+		if rs.EventID != 13 {
+			t.Errorf("speed %v: EventID is %d, want 13", tt.speed, rs.EventID)
+		}
+		if took < tt.min || took > tt.max {
+			t.Errorf("speed %v: took %s, want between %s and %s", tt.speed, took, tt.min, tt.max)
+		}
+	}
+}
+
+func TestReplayStreamStop(t *testing.T) {
+	rec, err := ReadRecording(strings.NewReader(testRecording))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rs := &ReplayStream{Recording: rec}
+	rs.Start()
+	<-rs.Events
+	rs.Stop()
+	rs.Wait()
+
+	// Events is closed, so ranging over it ends.
+	select {
+	case msg, ok := <-rs.Events:
+		if ok {
+			t.Errorf("have %v after Stop", msg)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Events not closed by Stop")
+	}
+
+	// Starting again replays from the start.
+	rs.Speed = -1
+	rs.Start()
+	defer rs.Stop()
+	if msg := <-rs.Events; msg[0].ID != 10 {
+		t.Errorf("have %v after restart", msg)
+	}
+}
+
+func TestReplayStreamEventID(t *testing.T) {
+	rec, err := ReadRecording(strings.NewReader(testRecording))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rs := &ReplayStream{Recording: rec, Speed: -1}
+	rs.Start()
+	<-rs.Events
+	// Give the replay time to block sending the second batch.
+	time.Sleep(10 * time.Millisecond)
+	rs.Stop()
+
+	// The second batch wasn't delivered, so EventID is the one of the
+	// first.
+	if rs.EventID != 10 {
+		t.Errorf("EventID is %d after Stop, want 10", rs.EventID)
+	}
+}
+
+func TestReplayStreamFinished(t *testing.T) {
+	rec, err := ReadRecording(strings.NewReader(testRecording))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rs := &ReplayStream{Recording: rec, Speed: -1}
+	rs.Start()
+	for range rs.Events {
+	}
+
+	// A finished replay can be started again without calling Stop.
+	if err := rs.Start(); err != nil {
+		t.Fatalf("Start after the replay finished: %v", err)
+	}
+	defer rs.Stop()
+	if msg := <-rs.Events; msg[0].ID != 10 {
+		t.Errorf("have %v after restart", msg)
+	}
+}
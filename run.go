@@ -0,0 +1,50 @@
+package fsevents
+
+import (
+	"sync"
+)
+
+// Run is a stream started with StartContext. It reports when the stream has
+// stopped, and why.
+type Run struct {
+	once sync.Once
+	done chan struct{}
+	err  error
+}
+
+func newRun() *Run {
+	return &Run{done: make(chan struct{})}
+}
+
+// Done returns a channel that's closed once the stream has stopped and its
+// Events channel has been closed.
+func (r *Run) Done() <-chan struct{} {
+	return r.done
+}
+
+// Wait waits until the stream has stopped and returns why: the error of the
+// context if it was done, the error of a Restart that failed or of the
+// Handler, or nil if Stop was called.
+func (r *Run) Wait() error {
+	<-r.done
+	return r.err
+}
+
+// Err returns nil if the stream is still running, and what Wait returns
+// otherwise.
+func (r *Run) Err() error {
+	select {
+	case <-r.done:
+		return r.err
+	default:
+		return nil
+	}
+}
+
+// finish records why the stream stopped, the first time it's called.
+func (r *Run) finish(err error) {
+	r.once.Do(func() {
+		r.err = err
+		close(r.done)
+	})
+}
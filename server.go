@@ -0,0 +1,353 @@
+//go:build darwin || linux
+
+package fsevents
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+)
+
+// defaultServerHistory is the Server.History used if it's not set.
+const defaultServerHistory = 1024
+
+// clientBuffer is the number of batches queued for a client before it's
+// considered too slow and disconnected. It resumes from where it was when it
+// reconnects.
+const clientBuffer = 256
+
+// Server serves the events of one or more EventStreams to Clients, on any
+// number of listeners:
+//
+//	srv := &fsevents.Server{}
+//	srv.Handle("src", &fsevents.EventStream{Paths: []string{"/src"}, Flags: fsevents.FileEvents})
+//	l, err := net.Listen("unix", "/run/fsevents.sock")
+//	...
+//	srv.Serve(l)
+//
+// The server keeps the most recent batches of every stream, so that clients
+// that reconnect with Resume set receive the batches they missed.
+type Server struct {
+	// History holds the number of batches kept for every stream. It
+	// defaults to 1024.
+	History int
+
+	mu        sync.Mutex
+	streams   map[string]*serverStream
+	listeners map[net.Listener]struct{}
+	conns     map[net.Conn]struct{}
+	closed    bool
+	wg        sync.WaitGroup
+}
+
+// serverStream is a stream served by a Server.
+type serverStream struct {
+	es   *EventStream
+	done chan struct{} // closed by Server.Close
+
+	mu      sync.Mutex
+	history [][]Event
+	dropped uint64 // ID of the last event dropped from history
+	clients map[chan []Event]struct{}
+	ended   bool // es.Events was closed
+}
+
+// Handle starts es and serves its events as the stream named name.
+func (s *Server) Handle(name string, es *EventStream) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return errors.New("fsevents: Server closed")
+	}
+	if _, ok := s.streams[name]; ok {
+		return fmt.Errorf("fsevents: stream %q already served", name)
+	}
+	if err := es.Start(); err != nil {
+		return err
+	}
+	if s.streams == nil {
+		s.streams = make(map[string]*serverStream)
+	}
+	ss := &serverStream{
+		es:      es,
+		done:    make(chan struct{}),
+		clients: make(map[chan []Event]struct{}),
+	}
+	s.streams[name] = ss
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		s.broadcast(ss)
+	}()
+	return nil
+}
+
+// Serve accepts connections from clients on l until the server is closed.
+// It always returns a non-nil error.
+func (s *Server) Serve(l net.Listener) error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return errors.New("fsevents: Server closed")
+	}
+	if s.listeners == nil {
+		s.listeners = make(map[net.Listener]struct{})
+	}
+	s.listeners[l] = struct{}{}
+	s.mu.Unlock()
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			s.mu.Lock()
+			delete(s.listeners, l)
+			closed := s.closed
+			s.mu.Unlock()
+			if closed {
+				return errors.New("fsevents: Server closed")
+			}
+			return err
+		}
+
+		s.mu.Lock()
+		if s.closed {
+			s.mu.Unlock()
+			conn.Close()
+			continue
+		}
+		if s.conns == nil {
+			s.conns = make(map[net.Conn]struct{})
+		}
+		s.conns[conn] = struct{}{}
+		s.wg.Add(1)
+		s.mu.Unlock()
+
+		go func() {
+			defer s.wg.Done()
+			s.serveConn(conn)
+
+			s.mu.Lock()
+			delete(s.conns, conn)
+			s.mu.Unlock()
+		}()
+	}
+}
+
+// Close stops the streams and closes the listeners and connections.
+func (s *Server) Close() error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return nil
+	}
+	s.closed = true
+	for l := range s.listeners {
+		l.Close()
+	}
+	for c := range s.conns {
+		c.Close()
+	}
+	for _, ss := range s.streams {
+		ss.es.Stop()
+		close(ss.done)
+	}
+	s.mu.Unlock()
+
+	s.wg.Wait()
+	return nil
+}
+
+func (s *Server) history() int {
+	if s.History <= 0 {
+		return defaultServerHistory
+	}
+	return s.History
+}
+
+// broadcast keeps the history of a stream and passes its batches on to the
+// clients.
+func (s *Server) broadcast(ss *serverStream) {
+	for {
+		var (
+			msg []Event
+			ok  bool
+		)
+		select {
+		case msg, ok = <-ss.es.Events:
+			if !ok {
+				// The stream was stopped; disconnect the clients.
+				ss.end()
+				return
+			}
+		case <-ss.done:
+			return
+		}
+
+		ss.mu.Lock()
+		ss.history = append(ss.history, msg)
+		if n := len(ss.history) - s.history(); n > 0 {
+			for _, b := range ss.history[:n] {
+				for _, e := range b {
+					if e.ID != 0 {
+						ss.dropped = e.ID
+					}
+				}
+			}
+			ss.history = append([][]Event(nil), ss.history[n:]...)
+		}
+		for c := range ss.clients {
+			select {
+			case c <- msg:
+			default:
+				// Too slow; disconnect it.
+				delete(ss.clients, c)
+				close(c)
+			}
+		}
+		ss.mu.Unlock()
+	}
+}
+
+// end closes the channels of the clients, once the stream was stopped.
+func (ss *serverStream) end() {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+
+	ss.ended = true
+	for c := range ss.clients {
+		delete(ss.clients, c)
+		close(c)
+	}
+}
+
+// subscribe registers a client of the stream, and returns the batches it
+// should receive before the live ones. It returns false if the stream was
+// stopped.
+func (ss *serverStream) subscribe(req remoteRequest) (chan []Event, [][]Event, bool) {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+
+	if ss.ended {
+		return nil, nil, false
+	}
+	c := make(chan []Event, clientBuffer)
+	ss.clients[c] = struct{}{}
+	if !req.Resume {
+		return c, nil, true
+	}
+
+	var history [][]Event
+	if req.EventID < ss.dropped {
+		// Some of the batches since EventID are no longer kept.
+		dropped := make([]Event, 0, len(ss.es.Paths))
+		for _, p := range ss.es.Paths {
+			dropped = append(dropped, Event{Path: p, Flags: MustScanSubDirs | UserDropped})
+		}
+		history = append(history, dropped)
+	}
+	for _, b := range ss.history {
+		if !hasEventAfter(b, req.EventID) {
+			continue
+		}
+		// Batches are sent whole, except for the events the client
+		// already has.
+		missed := make([]Event, 0, len(b))
+		for _, e := range b {
+			if e.ID == 0 || e.ID > req.EventID {
+				missed = append(missed, e)
+			}
+		}
+		history = append(history, missed)
+	}
+	return c, append(history, []Event{{Flags: HistoryDone}}), true
+}
+
+// hasEventAfter reports if any of events has an ID after id.
+func hasEventAfter(events []Event, id uint64) bool {
+	for _, e := range events {
+		if e.ID > id {
+			return true
+		}
+	}
+	return false
+}
+
+func (ss *serverStream) unsubscribe(c chan []Event) {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+
+	if _, ok := ss.clients[c]; ok {
+		delete(ss.clients, c)
+		close(c)
+	}
+}
+
+// serveConn serves a single client.
+func (s *Server) serveConn(conn net.Conn) {
+	defer conn.Close()
+
+	var (
+		req remoteRequest
+		enc = json.NewEncoder(conn)
+	)
+	line, err := bufio.NewReader(conn).ReadBytes('\n')
+	if err != nil {
+		return
+	}
+	if err := json.Unmarshal(line, &req); err != nil {
+		enc.Encode(remoteResponse{Error: "invalid request"})
+		return
+	}
+
+	s.mu.Lock()
+	ss, ok := s.streams[req.Stream]
+	s.mu.Unlock()
+	if !ok {
+		enc.Encode(remoteResponse{Error: fmt.Sprintf("no stream %q", req.Stream)})
+		return
+	}
+	// Subscribe before responding, so that the client doesn't miss events
+	// once it's started.
+	c, history, ok := ss.subscribe(req)
+	if !ok {
+		// Tell the client not to reconnect.
+		enc.Encode(remoteResponse{Error: fmt.Sprintf("stream %q stopped", req.Stream), Ended: true})
+		return
+	}
+	defer ss.unsubscribe(c)
+	if err := enc.Encode(remoteResponse{}); err != nil {
+		return
+	}
+
+	// The client doesn't send anything after the request; notice when it
+	// goes away.
+	go func() {
+		var b [1]byte
+		conn.Read(b[:])
+		ss.unsubscribe(c)
+	}()
+
+	for _, b := range history {
+		if err := enc.Encode(remoteBatch{Events: newRecordEvents(b)}); err != nil {
+			return
+		}
+	}
+	for {
+		select {
+		case b, ok := <-c:
+			if !ok {
+				return
+			}
+			if err := enc.Encode(remoteBatch{Events: newRecordEvents(b)}); err != nil {
+				return
+			}
+		case <-ss.done:
+			return
+		}
+	}
+}
@@ -0,0 +1,236 @@
+//go:build darwin || linux
+
+package fsevents
+
+import (
+	"errors"
+	"net"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// serve serves a poll stream of a temporary directory as "tmp".
+func serve(t *testing.T, srv *Server, network string) (*EventStream, string, string) {
+	t.Helper()
+	tmp := tempDir(t)
+
+	es := &EventStream{
+		Paths:        []string{tmp},
+		Flags:        FileEvents,
+		Backend:      PollBackend,
+		PollInterval: time.Hour,
+	}
+	if err := srv.Handle("tmp", es); err != nil {
+		t.Fatal(err)
+	}
+
+	addr := "127.0.0.1:0"
+	if network == "unix" {
+		addr = filepath.Join(t.TempDir(), "sock")
+	}
+	l, err := net.Listen(network, addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go srv.Serve(l)
+	t.Cleanup(func() { srv.Close() })
+	return es, tmp, l.Addr().String()
+}
+
+// receive returns the next batch from c, with the paths relative to tmp.
+func receive(t *testing.T, c *Client, tmp string) Events {
+	t.Helper()
+	select {
+	case msg := <-c.Events:
+		have := Events(msg)
+		have.TrimPrefix(tmp)
+		return have
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out")
+		return nil
+	}
+}
+
+// waitHistory waits until the latest event is in the history of ss; Flush
+// returns once it's received, but before it's added.
+func waitHistory(t *testing.T, ss *serverStream) {
+	t.Helper()
+	latest := atomic.LoadUint64(&lastEventID)
+	for start := time.Now(); time.Since(start) < 5*time.Second; time.Sleep(time.Millisecond) {
+		ss.mu.Lock()
+		ok := len(ss.history) > 0 && hasEventAfter(ss.history[len(ss.history)-1], latest-1)
+		ss.mu.Unlock()
+		if ok {
+			return
+		}
+	}
+	t.Fatal("timed out")
+}
+
+// checkBatches checks the next batches received by c.
+func checkBatches(t *testing.T, c *Client, tmp string, want []Events) {
+	t.Helper()
+	for i, w := range want {
+		if have := receive(t, c, tmp); have.String() != w.String() {
+			t.Errorf("batch %d:\nhave:\n%s\nwant:\n%s", i, indent(have), indent(w))
+		}
+	}
+}
+
+func TestServer(t *testing.T) {
+	srv := &Server{}
+	es, tmp, addr := serve(t, srv, "tcp")
+
+	c := &Client{Network: "tcp", Address: addr, Stream: "tmp", Events: make(chan []Event, 16)}
+	if err := c.Start(); err != nil {
+		t.Fatal(err)
+	}
+	touch(t, tmp, "a")
+	es.Flush(true)
+	want := Events{{Path: "/a", Flags: ItemCreated | ItemIsFile}}
+	if have := receive(t, c, tmp); have.String() != want.String() {
+		t.Fatalf("\nhave:\n%s\nwant:\n%s", indent(have), indent(want))
+	}
+	id := c.EventID
+	if id == 0 {
+		t.Fatal("EventID not set")
+	}
+
+	// Missed while disconnected.
+	c.Stop()
+	touch(t, tmp, "b")
+	es.Flush(true)
+	touch(t, tmp, "c")
+	es.Flush(true)
+	waitHistory(t, srv.streams["tmp"])
+
+	if err := c.Restart(); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Stop()
+	touch(t, tmp, "d")
+	es.Flush(true)
+
+	checkBatches(t, c, tmp, []Events{
+		{{Path: "/b", Flags: ItemCreated | ItemIsFile}},
+		{{Path: "/c", Flags: ItemCreated | ItemIsFile}},
+		{{Flags: HistoryDone}},
+		{{Path: "/d", Flags: ItemCreated | ItemIsFile}},
+	})
+	if c.EventID <= id {
+		t.Errorf("EventID %d not after %d", c.EventID, id)
+	}
+}
+
+func TestServerReconnect(t *testing.T) {
+	srv := &Server{History: 1}
+	es, tmp, addr := serve(t, srv, "unix")
+
+	c := &Client{Network: "unix", Address: addr, Stream: "nope"}
+	if err := c.Start(); err == nil || !strings.Contains(err.Error(), `no stream "nope"`) {
+		t.Fatalf("wrong error: %v", err)
+	}
+
+	c = &Client{
+		Network:       "unix",
+		Address:       addr,
+		Stream:        "tmp",
+		Events:        make(chan []Event, 16),
+		RetryInterval: 10 * time.Millisecond,
+	}
+	if err := c.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Stop()
+
+	touch(t, tmp, "a")
+	es.Flush(true)
+	receive(t, c, tmp)
+
+	// Drop the connection without the client knowing; more batches than
+	// the server keeps are missed.
+	srv.mu.Lock()
+	for conn := range srv.conns {
+		conn.Close()
+	}
+	touch(t, tmp, "b")
+	es.Flush(true)
+	touch(t, tmp, "c")
+	es.Flush(true)
+	waitHistory(t, srv.streams["tmp"])
+	srv.mu.Unlock()
+	checkBatches(t, c, tmp, []Events{
+		{{Path: "/", Flags: MustScanSubDirs | UserDropped}},
+		{{Path: "/c", Flags: ItemCreated | ItemIsFile}},
+		{{Flags: HistoryDone}},
+	})
+}
+
+func TestServerStreamStopped(t *testing.T) {
+	srv := &Server{}
+	es, tmp, addr := serve(t, srv, "tcp")
+
+	c := &Client{
+		Network:       "tcp",
+		Address:       addr,
+		Stream:        "tmp",
+		Resume:        true,
+		Events:        make(chan []Event, 16),
+		RetryInterval: 10 * time.Millisecond,
+	}
+	if err := c.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Stop()
+	checkBatches(t, c, tmp, []Events{{{Flags: HistoryDone}}})
+
+	// The client stops once the stream is stopped on the server, rather
+	// than reconnecting.
+	es.Stop()
+	select {
+	case msg, ok := <-c.Events:
+		if ok {
+			t.Fatalf("received %v", msg)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Events not closed")
+	}
+	if err := c.Err(); !errors.Is(err, ErrStreamEnded) {
+		t.Errorf("Err returned %v, want %v", err, ErrStreamEnded)
+	}
+
+	// Later clients aren't started.
+	if err := c.Start(); !errors.Is(err, ErrStreamEnded) {
+		t.Errorf("Start returned %v, want %v", err, ErrStreamEnded)
+	}
+}
+
+func TestClientStop(t *testing.T) {
+	srv := &Server{}
+	_, _, addr := serve(t, srv, "tcp")
+
+	c := &Client{Network: "tcp", Address: addr, Stream: "tmp"}
+	if err := c.Start(); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Restart(); err != nil {
+		t.Fatal(err)
+	}
+	events := c.Events
+	c.Stop()
+	for range events {
+		t.Error("batch received after Stop")
+	}
+
+	// Start replaces the closed channel.
+	if err := c.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Stop()
+	if c.Events == events {
+		t.Error("Events not replaced")
+	}
+}
@@ -0,0 +1,18 @@
+//go:build darwin
+
+package fsevents
+
+import (
+	"syscall"
+	"time"
+)
+
+// statCtime returns the time the inode was last changed.
+func statCtime(st *syscall.Stat_t) time.Time {
+	return time.Unix(st.Ctimespec.Unix())
+}
+
+// statDev returns the device the file is on.
+func statDev(st *syscall.Stat_t) DeviceID {
+	return DeviceID(uint32(st.Dev))
+}
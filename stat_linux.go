@@ -0,0 +1,37 @@
+//go:build linux
+
+package fsevents
+
+import (
+	"syscall"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// statCtime returns the time the inode was last changed.
+func statCtime(st *syscall.Stat_t) time.Time {
+	return time.Unix(st.Ctim.Unix())
+}
+
+// statDev returns the device the file is on.
+func statDev(st *syscall.Stat_t) DeviceID {
+	return DeviceID(st.Dev)
+}
+
+// statInode returns the inode of the item at path, without following
+// symlinks, and ItemIsHardlink if it's a file with more than one link. It
+// returns zero if the item doesn't exist.
+func statInode(path string) (uint64, EventFlags) {
+	var st unix.Statx_t
+	err := unix.Statx(unix.AT_FDCWD, path, unix.AT_SYMLINK_NOFOLLOW|unix.AT_STATX_DONT_SYNC,
+		unix.STATX_TYPE|unix.STATX_INO|unix.STATX_NLINK, &st)
+	if err != nil {
+		return 0, 0
+	}
+	var flags EventFlags
+	if st.Mode&unix.S_IFMT != unix.S_IFDIR && st.Nlink > 1 {
+		flags = ItemIsHardlink
+	}
+	return st.Ino, flags
+}
@@ -0,0 +1,95 @@
+package fsevents
+
+import (
+	"time"
+)
+
+// Stats holds statistics about the batches an EventStream has delivered, as
+// returned by EventStream.Stats. They accumulate for the lifetime of the
+// stream, across restarts.
+type Stats struct {
+	// Batches and Events hold how many batches and events were received
+	// from Events, and LargestBatch the number of events in the largest
+	// batch.
+	Batches      uint64
+	Events       uint64
+	LargestBatch int
+
+	// DroppedBatches and DroppedEvents hold how many batches and events
+	// were dropped by the Overflow policy.
+	DroppedBatches uint64
+	DroppedEvents  uint64
+
+	// MustScanSubDirs, KernelDropped and UserDropped hold how many of the
+	// events received had these flags, which report that events were
+	// dropped before they could be delivered.
+	MustScanSubDirs uint64
+	KernelDropped   uint64
+	UserDropped     uint64
+
+	// LastBatch holds when the last batch was received, and
+	// SinceLastBatch how long ago that was. Both are zero if no batch was.
+	LastBatch      time.Time
+	SinceLastBatch time.Duration
+
+	// Blocked holds how long the stream has waited, in total, for the
+	// consumer to receive from Events.
+	Blocked time.Duration
+}
+
+// add records a batch that was received after waiting blocked for it to be.
+func (s *Stats) add(events []Event, blocked time.Duration, now time.Time) {
+	s.Batches++
+	s.Events += uint64(len(events))
+	if len(events) > s.LargestBatch {
+		s.LargestBatch = len(events)
+	}
+	for _, e := range events {
+		if e.Flags&MustScanSubDirs != 0 {
+			s.MustScanSubDirs++
+		}
+		if e.Flags&KernelDropped != 0 {
+			s.KernelDropped++
+		}
+		if e.Flags&UserDropped != 0 {
+			s.UserDropped++
+		}
+	}
+	s.LastBatch = now
+	s.Blocked += blocked
+}
+
+// sendBatch sends events on out, unless done is closed first. It returns how
+// long it waited for out to be received from.
+func sendBatch(out chan<- []Event, events []Event, done <-chan struct{}) (time.Duration, bool) {
+	select {
+	case out <- events:
+		return 0, true
+	default:
+	}
+
+	start := time.Now()
+	select {
+	case out <- events:
+		return time.Since(start), true
+	case <-done:
+		return 0, false
+	}
+}
+
+// sendEvent is sendBatch for a single event.
+func sendEvent(out chan<- Event, event Event, done <-chan struct{}) (time.Duration, bool) {
+	select {
+	case out <- event:
+		return 0, true
+	default:
+	}
+
+	start := time.Now()
+	select {
+	case out <- event:
+		return time.Since(start), true
+	case <-done:
+		return 0, false
+	}
+}
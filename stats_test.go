@@ -0,0 +1,97 @@
+//go:build darwin || linux
+
+package fsevents
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStats(t *testing.T) {
+	es := &EventStream{Paths: []string{"/a"}}
+	es.delivered([]Event{{Path: "/a/1", ID: 1}, {Path: "/a", Flags: MustScanSubDirs | KernelDropped, ID: 2}}, 0)
+	es.delivered([]Event{{Path: "/a", Flags: MustScanSubDirs | UserDropped, ID: 3}}, time.Second)
+	es.delivered([]Event{{Path: "/a/4", ID: 4}}, 2*time.Second)
+	es.dropped(2, 5)
+
+	s := es.Stats()
+	if s.LastBatch.IsZero() || s.SinceLastBatch < 0 || s.SinceLastBatch > time.Minute {
+		t.Errorf("LastBatch is %s, SinceLastBatch %s", s.LastBatch, s.SinceLastBatch)
+	}
+	s.LastBatch, s.SinceLastBatch = time.Time{}, 0
+	want := Stats{
+		Batches:         3,
+		Events:          4,
+		LargestBatch:    2,
+		DroppedBatches:  2,
+		DroppedEvents:   5,
+		MustScanSubDirs: 2,
+		KernelDropped:   1,
+		UserDropped:     1,
+		Blocked:         3 * time.Second,
+	}
+	if s != want {
+		t.Errorf("\nhave: %+v\nwant: %+v", s, want)
+	}
+}
+
+func TestStatsBlocked(t *testing.T) {
+	tmp := tempDir(t)
+	es := &EventStream{
+		Paths:        []string{tmp},
+		Flags:        FileEvents,
+		Backend:      PollBackend,
+		PollInterval: time.Hour,
+	}
+	if err := es.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer es.Stop()
+
+	if s := es.Stats(); s != (Stats{}) {
+		t.Errorf("stats before any batch: %+v", s)
+	}
+
+	touch(t, tmp, "file")
+	flushed := make(chan struct{})
+	go func() {
+		es.Flush(true)
+		close(flushed)
+	}()
+	time.Sleep(50 * time.Millisecond)
+	<-es.Events
+	<-flushed
+
+	s := es.Stats()
+	if s.Batches != 1 || s.Events != 1 || s.LargestBatch != 1 {
+		t.Errorf("%+v", s)
+	}
+	if s.Blocked < 40*time.Millisecond {
+		t.Errorf("Blocked is %s; the consumer waited 50ms", s.Blocked)
+	}
+}
+
+func TestDescribe(t *testing.T) {
+	tmp := tempDir(t)
+	es := &EventStream{
+		Paths:        []string{tmp},
+		Backend:      PollBackend,
+		PollInterval: time.Hour,
+	}
+	if d := es.Describe(); d.Text != "" || d.Paths != nil {
+		t.Errorf("stream isn't started: %+v", d)
+	}
+	if err := es.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer es.Stop()
+
+	d := es.Describe()
+	if len(d.Paths) != 1 || d.Paths[0] != tmp {
+		t.Errorf("Paths is %q, want %q", d.Paths, tmp)
+	}
+	if !strings.Contains(d.Text, "interval = 1h0m0s") {
+		t.Errorf("Text is %q", d.Text)
+	}
+}
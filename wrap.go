@@ -11,7 +11,35 @@ static CFArrayRef ArrayCreateMutable(int len) {
 	return CFArrayCreateMutable(NULL, len, &kCFTypeArrayCallBacks);
 }
 
-extern void fsevtCallback(FSEventStreamRef p0, uintptr_t info, size_t p1, char** p2, FSEventStreamEventFlags* p3, FSEventStreamEventId* p4);
+extern void fsevtCallback(FSEventStreamRef p0, uintptr_t info, size_t p1, void* p2, FSEventStreamEventFlags* p3, FSEventStreamEventId* p4);
+
+// EventData returns the path of the i-th event of a stream created with
+// kFSEventStreamCreateFlagUseCFTypes, which has to be freed, and sets inode to
+// the inode of the item, or 0 if it isn't known. The events are dictionaries
+// with the path and inode if the stream was created with
+// kFSEventStreamCreateFlagUseExtendedData, and only the paths otherwise.
+static char* EventData(CFArrayRef data, CFIndex i, SInt64 *inode) {
+	CFTypeRef item = CFArrayGetValueAtIndex(data, i);
+	CFStringRef path = item;
+	*inode = 0;
+	if (CFGetTypeID(item) == CFDictionaryGetTypeID()) {
+		CFNumberRef n = CFDictionaryGetValue(item, kFSEventStreamEventExtendedFileIDKey);
+		if (n != NULL) {
+			CFNumberGetValue(n, kCFNumberSInt64Type, inode);
+		}
+		path = CFDictionaryGetValue(item, kFSEventStreamEventExtendedDataPathKey);
+	}
+	if (path == NULL) {
+		return NULL;
+	}
+	CFIndex size = CFStringGetMaximumSizeOfFileSystemRepresentation(path);
+	char *buf = malloc(size);
+	if (buf != NULL && !CFStringGetFileSystemRepresentation(path, buf, size)) {
+		free(buf);
+		return NULL;
+	}
+	return buf;
+}
 
 static FSEventStreamRef EventStreamCreateRelativeToDevice(FSEventStreamContext * context, uintptr_t info, dev_t dev, CFArrayRef paths, FSEventStreamEventId since, CFTimeInterval latency, FSEventStreamCreateFlags flags) {
 	context->info = (void*) info;
@@ -29,204 +57,50 @@ static void DispatchQueueRelease(dispatch_queue_t queue) {
 */
 import "C"
 import (
+	"errors"
 	"fmt"
-	"log"
 	"path/filepath"
 	"reflect"
 	"runtime"
+	"sync"
 	"time"
 	"unsafe"
 )
 
-// CreateFlags specifies what events will be seen in an event stream.
-type CreateFlags uint32
-
-const (
-	// NoDefer sends events on the leading edge (for interactive applications).
-	// By default events are delivered after latency seconds (for background tasks).
-	//
-	// Affects the meaning of the EventStream.Latency parameter. If you specify
-	// this flag and more than latency seconds have elapsed since
-	// the last event, your app will receive the event immediately.
-	// The delivery of the event resets the latency timer and any
-	// further events will be delivered after latency seconds have
-	// elapsed. This flag is useful for apps that are interactive
-	// and want to react immediately to changes but avoid getting
-	// swamped by notifications when changes are occurring in rapid
-	// succession. If you do not specify this flag, then when an
-	// event occurs after a period of no events, the latency timer
-	// is started. Any events that occur during the next latency
-	// seconds will be delivered as one group (including that first
-	// event). The delivery of the group of events resets the
-	// latency timer and any further events will be delivered after
-	// latency seconds. This is the default behavior and is more
-	// appropriate for background, daemon or batch processing apps.
-	NoDefer = CreateFlags(C.kFSEventStreamCreateFlagNoDefer)
-
-	// WatchRoot requests notifications of changes along the path to
-	// the path(s) you're watching. For example, with this flag, if
-	// you watch "/foo/bar" and it is renamed to "/foo/bar.old", you
-	// would receive a RootChanged event. The same is true if the
-	// directory "/foo" were renamed. The event you receive is a
-	// special event: the path for the event is the original path
-	// you specified, the flag RootChanged is set and event ID is
-	// zero. RootChanged events are useful to indicate that you
-	// should rescan a particular hierarchy because it changed
-	// completely (as opposed to the things inside of it changing).
-	// If you want to track the current location of a directory, it
-	// is best to open the directory before creating the stream so
-	// that you have a file descriptor for it and can issue an
-	// F_GETPATH fcntl() to find the current path.
-	WatchRoot = CreateFlags(C.kFSEventStreamCreateFlagWatchRoot)
-
-	// IgnoreSelf doesn't send events triggered by the current process (macOS 10.6+).
-	//
-	// Don't send events that were triggered by the current process.
-	// This is useful for reducing the volume of events that are
-	// sent. It is only useful if your process might modify the file
-	// system hierarchy beneath the path(s) being monitored. Note:
-	// this has no effect on historical events, i.e., those
-	// delivered before the HistoryDone sentinel event.
-	IgnoreSelf = CreateFlags(C.kFSEventStreamCreateFlagIgnoreSelf)
-
-	// FileEvents sends events about individual files, generating significantly
-	// more events (macOS 10.7+) than directory level notifications.
-	FileEvents = CreateFlags(C.kFSEventStreamCreateFlagFileEvents)
-)
-
-// EventFlags passed to the FSEventStreamCallback function.
-// These correspond directly to the flags as described here:
-// https://developer.apple.com/documentation/coreservices/1455361-fseventstreameventflags
-type EventFlags uint32
-
-const (
-	// MustScanSubDirs indicates that events were coalesced hierarchically.
-	//
-	// Your application must rescan not just the directory given in
-	// the event, but all its children, recursively. This can happen
-	// if there was a problem whereby events were coalesced
-	// hierarchically. For example, an event in /Users/jsmith/Music
-	// and an event in /Users/jsmith/Pictures might be coalesced
-	// into an event with this flag set and path=/Users/jsmith. If
-	// this flag is set you may be able to get an idea of whether
-	// the bottleneck happened in the kernel (less likely) or in
-	// your client (more likely) by checking for the presence of the
-	// informational flags UserDropped or KernelDropped.
-	MustScanSubDirs EventFlags = EventFlags(C.kFSEventStreamEventFlagMustScanSubDirs)
-
-	// KernelDropped or UserDropped may be set in addition
-	// to the MustScanSubDirs flag to indicate that a problem
-	// occurred in buffering the events (the particular flag set
-	// indicates where the problem occurred) and that the client
-	// must do a full scan of any directories (and their
-	// subdirectories, recursively) being monitored by this stream.
-	// If you asked to monitor multiple paths with this stream then
-	// you will be notified about all of them. Your code need only
-	// check for the MustScanSubDirs flag; these flags (if present)
-	// only provide information to help you diagnose the problem.
-	KernelDropped = EventFlags(C.kFSEventStreamEventFlagKernelDropped)
-
-	// UserDropped is related to UserDropped above.
-	UserDropped = EventFlags(C.kFSEventStreamEventFlagUserDropped)
-
-	// EventIDsWrapped indicates the 64-bit event ID counter wrapped around.
-	//
-	// If EventIdsWrapped is set, it means
-	// the 64-bit event ID counter wrapped around. As a result,
-	// previously-issued event ID's are no longer valid
-	// for the EventID field when using EventStream.Resume.
-	EventIDsWrapped = EventFlags(C.kFSEventStreamEventFlagEventIdsWrapped)
-
-	// HistoryDone is a sentinel event when retrieving events with EventStream.Resume.
-	//
-	// Denotes a sentinel event sent to mark the end of the
-	// "historical" events sent as a result of specifying
-	// EventStream.Resume.
-	//
-	// After sending all the "historical" events that occurred before now,
-	// an event will be sent with the HistoryDone flag set. The client
-	// should ignore the path supplied in that event.
-	HistoryDone = EventFlags(C.kFSEventStreamEventFlagHistoryDone)
-
-	// RootChanged indicates a change to a directory along the path being watched.
-	//
-	// Denotes a special event sent when there is a change to one of
-	// the directories along the path to one of the directories you
-	// asked to watch. When this flag is set, the event ID is zero
-	// and the path corresponds to one of the paths you asked to
-	// watch (specifically, the one that changed). The path may no
-	// longer exist because it or one of its parents was deleted or
-	// renamed. Events with this flag set will only be sent if you
-	// passed the flag WatchRoot when you created the stream.
-	RootChanged = EventFlags(C.kFSEventStreamEventFlagRootChanged)
-
-	// Mount for a volume mounted underneath the path being monitored.
-	//
-	// Denotes a special event sent when a volume is mounted
-	// underneath one of the paths being monitored. The path in the
-	// event is the path to the newly-mounted volume. You will
-	// receive one of these notifications for every volume mount
-	// event inside the kernel (independent of DiskArbitration).
-	// Beware that a newly-mounted volume could contain an
-	// arbitrarily large directory hierarchy. Avoid pitfalls like
-	// triggering a recursive scan of a non-local filesystem, which
-	// you can detect by checking for the absence of the MNT_LOCAL
-	// flag in the f_flags returned by statfs(). Also be aware of
-	// the MNT_DONTBROWSE flag that is set for volumes which should
-	// not be displayed by user interface elements.
-	Mount = EventFlags(C.kFSEventStreamEventFlagMount)
-
-	// Unmount event occurs after a volume is unmounted.
-	//
-	// Denotes a special event sent when a volume is unmounted
-	// underneath one of the paths being monitored. The path in the
-	// event is the path to the directory from which the volume was
-	// unmounted. You will receive one of these notifications for
-	// every volume unmount event inside the kernel. This is not a
-	// substitute for the notifications provided by the
-	// DiskArbitration framework; you only get notified after the
-	// unmount has occurred. Beware that unmounting a volume could
-	// uncover an arbitrarily large directory hierarchy, although
-	// macOS never does that.
-	Unmount = EventFlags(C.kFSEventStreamEventFlagUnmount)
-
-	// The following flags are only set when using FileEvents.
-
-	// ItemCreated indicates that a file or directory has been created.
-	ItemCreated = EventFlags(C.kFSEventStreamEventFlagItemCreated)
-
-	// ItemRemoved indicates that a file or directory has been removed.
-	ItemRemoved = EventFlags(C.kFSEventStreamEventFlagItemRemoved)
-
-	// ItemInodeMetaMod indicates that a file or directory's metadata has has been modified.
-	ItemInodeMetaMod = EventFlags(C.kFSEventStreamEventFlagItemInodeMetaMod)
-
-	// ItemRenamed indicates that a file or directory has been renamed.
-	// TODO is there any indication what it might have been renamed to?
-	ItemRenamed = EventFlags(C.kFSEventStreamEventFlagItemRenamed)
-
-	// ItemModified indicates that a file has been modified.
-	ItemModified = EventFlags(C.kFSEventStreamEventFlagItemModified)
-
-	// ItemFinderInfoMod indicates the the item's Finder information has been
-	// modified.
-	// TODO the above is just a guess.
-	ItemFinderInfoMod = EventFlags(C.kFSEventStreamEventFlagItemFinderInfoMod)
-
-	// ItemChangeOwner indicates that the file has changed ownership.
-	ItemChangeOwner = EventFlags(C.kFSEventStreamEventFlagItemChangeOwner)
-
-	// ItemXattrMod indicates that the files extended attributes have changed.
-	ItemXattrMod = EventFlags(C.kFSEventStreamEventFlagItemXattrMod)
-
-	// ItemIsFile indicates that the item is a file.
-	ItemIsFile = EventFlags(C.kFSEventStreamEventFlagItemIsFile)
-
-	// ItemIsDir indicates that the item is a directory.
-	ItemIsDir = EventFlags(C.kFSEventStreamEventFlagItemIsDir)
-
-	// ItemIsSymlink indicates that the item is a symbolic link.
-	ItemIsSymlink = EventFlags(C.kFSEventStreamEventFlagItemIsSymlink)
+// The flags are defined in flags.go without cgo. Make sure they match the
+// values in FSEvents.h: indexing a single element array with a non-zero
+// constant is a compile error.
+var (
+	_ = [1]struct{}{}[NoDefer^CreateFlags(C.kFSEventStreamCreateFlagNoDefer)]
+	_ = [1]struct{}{}[WatchRoot^CreateFlags(C.kFSEventStreamCreateFlagWatchRoot)]
+	_ = [1]struct{}{}[IgnoreSelf^CreateFlags(C.kFSEventStreamCreateFlagIgnoreSelf)]
+	_ = [1]struct{}{}[FileEvents^CreateFlags(C.kFSEventStreamCreateFlagFileEvents)]
+	_ = [1]struct{}{}[MarkSelf^CreateFlags(C.kFSEventStreamCreateFlagMarkSelf)]
+	_ = [1]struct{}{}[UseExtendedData^CreateFlags(C.kFSEventStreamCreateFlagUseExtendedData)]
+	_ = [1]struct{}{}[FullHistory^CreateFlags(C.kFSEventStreamCreateFlagFullHistory)]
+	_ = [1]struct{}{}[MustScanSubDirs^EventFlags(C.kFSEventStreamEventFlagMustScanSubDirs)]
+	_ = [1]struct{}{}[KernelDropped^EventFlags(C.kFSEventStreamEventFlagKernelDropped)]
+	_ = [1]struct{}{}[UserDropped^EventFlags(C.kFSEventStreamEventFlagUserDropped)]
+	_ = [1]struct{}{}[EventIDsWrapped^EventFlags(C.kFSEventStreamEventFlagEventIdsWrapped)]
+	_ = [1]struct{}{}[HistoryDone^EventFlags(C.kFSEventStreamEventFlagHistoryDone)]
+	_ = [1]struct{}{}[RootChanged^EventFlags(C.kFSEventStreamEventFlagRootChanged)]
+	_ = [1]struct{}{}[Mount^EventFlags(C.kFSEventStreamEventFlagMount)]
+	_ = [1]struct{}{}[Unmount^EventFlags(C.kFSEventStreamEventFlagUnmount)]
+	_ = [1]struct{}{}[ItemCreated^EventFlags(C.kFSEventStreamEventFlagItemCreated)]
+	_ = [1]struct{}{}[ItemRemoved^EventFlags(C.kFSEventStreamEventFlagItemRemoved)]
+	_ = [1]struct{}{}[ItemInodeMetaMod^EventFlags(C.kFSEventStreamEventFlagItemInodeMetaMod)]
+	_ = [1]struct{}{}[ItemRenamed^EventFlags(C.kFSEventStreamEventFlagItemRenamed)]
+	_ = [1]struct{}{}[ItemModified^EventFlags(C.kFSEventStreamEventFlagItemModified)]
+	_ = [1]struct{}{}[ItemFinderInfoMod^EventFlags(C.kFSEventStreamEventFlagItemFinderInfoMod)]
+	_ = [1]struct{}{}[ItemChangeOwner^EventFlags(C.kFSEventStreamEventFlagItemChangeOwner)]
+	_ = [1]struct{}{}[ItemXattrMod^EventFlags(C.kFSEventStreamEventFlagItemXattrMod)]
+	_ = [1]struct{}{}[ItemIsFile^EventFlags(C.kFSEventStreamEventFlagItemIsFile)]
+	_ = [1]struct{}{}[ItemIsDir^EventFlags(C.kFSEventStreamEventFlagItemIsDir)]
+	_ = [1]struct{}{}[ItemIsSymlink^EventFlags(C.kFSEventStreamEventFlagItemIsSymlink)]
+	_ = [1]struct{}{}[OwnEvent^EventFlags(C.kFSEventStreamEventFlagOwnEvent)]
+	_ = [1]struct{}{}[ItemIsHardlink^EventFlags(C.kFSEventStreamEventFlagItemIsHardlink)]
+	_ = [1]struct{}{}[ItemIsLastHardlink^EventFlags(C.kFSEventStreamEventFlagItemIsLastHardlink)]
+	_ = [1]struct{}{}[ItemCloned^EventFlags(C.kFSEventStreamEventFlagItemCloned)]
 )
 
 const (
@@ -235,6 +109,11 @@ const (
 
 	// eventIDSinceNow is a sentinel to begin watching events "since now".
 	eventIDSinceNow = uint64(C.kFSEventStreamEventIdSinceNow)
+
+	// useCFTypes makes FSEvents pass the paths as a CFArray, which
+	// UseExtendedData requires. It's always set, so that the callback
+	// reads the paths the same way with or without it.
+	useCFTypes = CreateFlags(C.kFSEventStreamCreateFlagUseCFTypes)
 )
 
 // GetDeviceUUID retrieves the UUID required to identify an EventID
@@ -252,34 +131,124 @@ func LatestEventID() uint64 {
 	return uint64(C.FSEventsGetCurrentEventId())
 }
 
+// eventStreamRegistry is a lookup table for EventStream references passed to
+// cgo. In Go 1.6+ passing a Go pointer to a Go pointer to cgo is not allowed.
+// To get around this issue, we pass only an integer.
+type eventStreamRegistry struct {
+	sync.Mutex
+	m      map[uintptr]*registryEntry
+	lastID uintptr
+}
+
+// registryEntry is an EventStream in the registry, with the callbacks that
+// are delivering events to it.
+type registryEntry struct {
+	es        *EventStream
+	done      chan struct{} // closed by Cancel
+	cancelled bool
+	calls     sync.WaitGroup // callbacks in progress
+}
+
+var registry = eventStreamRegistry{m: map[uintptr]*registryEntry{}}
+
+func (r *eventStreamRegistry) Add(e *EventStream) uintptr {
+	r.Lock()
+	defer r.Unlock()
+
+	r.lastID++
+	r.m[r.lastID] = &registryEntry{es: e, done: make(chan struct{})}
+	return r.lastID
+}
+
+func (r *eventStreamRegistry) Get(i uintptr) *EventStream {
+	r.Lock()
+	defer r.Unlock()
+
+	if e, ok := r.m[i]; ok {
+		return e.es
+	}
+	return nil
+}
+
+// Acquire returns the entry for a callback, or nil if there's none. The
+// callback must call entry.calls.Done when it returns.
+func (r *eventStreamRegistry) Acquire(i uintptr) *registryEntry {
+	r.Lock()
+	defer r.Unlock()
+
+	e, ok := r.m[i]
+	if !ok {
+		return nil
+	}
+	e.calls.Add(1)
+	return e
+}
+
+// Cancel makes the callbacks drop their events instead of delivering them.
+func (r *eventStreamRegistry) Cancel(i uintptr) {
+	r.Lock()
+	defer r.Unlock()
+
+	if e, ok := r.m[i]; ok && !e.cancelled {
+		e.cancelled = true
+		close(e.done)
+	}
+}
+
+// Delete removes an entry once the callbacks in progress have returned. Those
+// that are delivering events drop them.
+func (r *eventStreamRegistry) Delete(i uintptr) {
+	r.Cancel(i)
+
+	r.Lock()
+	e, ok := r.m[i]
+	delete(r.m, i)
+	r.Unlock()
+
+	if ok {
+		e.calls.Wait()
+	}
+}
+
 // arguments are released by C at the end of the callback. Ensure copies
 // are made if data is expected to persist beyond this function ending.
 //
 //export fsevtCallback
-func fsevtCallback(stream C.FSEventStreamRef, info uintptr, numEvents C.size_t, cpaths **C.char, cflags *C.FSEventStreamEventFlags, cids *C.FSEventStreamEventId) {
+func fsevtCallback(stream C.FSEventStreamRef, info uintptr, numEvents C.size_t, cdata unsafe.Pointer, cflags *C.FSEventStreamEventFlags, cids *C.FSEventStreamEventId) {
 	l := int(numEvents)
 	events := make([]Event, l)
 
-	es := registry.Get(info)
-	if es == nil {
-		log.Printf("failed to retrieve registry %d", info)
+	// Streams are only removed from the registry once they're stopped
+	// and invalidated, so there's no one to deliver to.
+	entry := registry.Acquire(info)
+	if entry == nil {
+		packageLogger().Debug("fsevents: events for a stopped stream", "events", l)
 		return
 	}
+	defer entry.calls.Done()
+
 	// These slices are backed by C data. Ensure data is copied out
 	// if it expected to exist outside of this function.
-	paths := (*[1 << 30]*C.char)(unsafe.Pointer(cpaths))[:l:l]
 	ids := (*[1 << 30]C.FSEventStreamEventId)(unsafe.Pointer(cids))[:l:l]
 	flags := (*[1 << 30]C.FSEventStreamEventFlags)(unsafe.Pointer(cflags))[:l:l]
+	// The stream is created with useCFTypes, so the paths come in a
+	// CFArray, with the inodes if UseExtendedData is set.
+	data := C.CFArrayRef(cdata)
 	for i := range events {
+		var inode C.SInt64
+		path := C.EventData(data, C.CFIndex(i), &inode)
 		events[i] = Event{
-			Path:  C.GoString(paths[i]),
+			Path:  C.GoString(path),
 			Flags: EventFlags(flags[i]),
 			ID:    uint64(ids[i]),
+			Inode: uint64(inode),
 		}
-		es.EventID = uint64(ids[i])
+		C.free(unsafe.Pointer(path))
 	}
 
-	es.Events <- events
+	// The events are dropped if the stream is stopped meanwhile. EventID
+	// isn't updated then, so they're sent again by Restart.
+	entry.es.deliver(events, entry.done)
 }
 
 type fsDispatchQueueRef C.dispatch_queue_t
@@ -365,22 +334,16 @@ func EventIDForDeviceBeforeTime(dev int32, before time.Time) uint64 {
 // compatible array of paths
 func createPaths(paths []string) (C.CFArrayRef, error) {
 	cPaths := C.ArrayCreateMutable(C.int(len(paths)))
-	var errs []error
 	for _, path := range paths {
 		p, err := filepath.Abs(path)
 		if err != nil {
-			// hack up some reporting errors, but don't prevent execution
-			// because of them
-			errs = append(errs, err)
+			C.CFRelease(C.CFTypeRef(cPaths))
+			return nil, newPathError("abs", path, err)
 		}
 		str := makeCFString(p)
 		C.CFArrayAppendValue(C.CFMutableArrayRef(cPaths), unsafe.Pointer(str))
 	}
-	var err error
-	if len(errs) > 0 {
-		err = fmt.Errorf("%q", errs)
-	}
-	return cPaths, err
+	return cPaths, nil
 }
 
 // makeCFString makes an immutable string with CFStringCreateWithCString.
@@ -398,10 +361,10 @@ func cfArrayLen(ref C.CFArrayRef) int {
 	return int(C.CFArrayGetCount(ref))
 }
 
-func setupStream(paths []string, flags CreateFlags, callbackInfo uintptr, eventID uint64, latency time.Duration, deviceID int32) fsEventStreamRef {
+func setupStream(paths []string, flags CreateFlags, callbackInfo uintptr, eventID uint64, latency time.Duration, deviceID int32) (fsEventStreamRef, error) {
 	cPaths, err := createPaths(paths)
 	if err != nil {
-		log.Printf("Error creating paths: %s", err)
+		return nil, err
 	}
 	defer C.CFRelease(C.CFTypeRef(cPaths))
 
@@ -409,6 +372,8 @@ func setupStream(paths []string, flags CreateFlags, callbackInfo uintptr, eventI
 	context := C.FSEventStreamContext{}
 	info := C.uintptr_t(callbackInfo)
 	cfinv := C.CFTimeInterval(float64(latency) / float64(time.Second))
+	// fsevtCallback expects the paths in a CFArray.
+	flags |= useCFTypes
 
 	var ref C.FSEventStreamRef
 	if deviceID != 0 {
@@ -420,31 +385,72 @@ func setupStream(paths []string, flags CreateFlags, callbackInfo uintptr, eventI
 			cfinv, C.FSEventStreamCreateFlags(flags))
 	}
 
-	return fsEventStreamRef(ref)
+	return fsEventStreamRef(ref), nil
+}
+
+// fsEvents is the backend that feeds an EventStream from a CoreServices
+// FSEventStream.
+type fsEvents struct {
+	es         *EventStream
+	mu         sync.RWMutex // guards stream while flushing
+	stream     fsEventStreamRef
+	qref       fsDispatchQueueRef
+	registryID uintptr
+	uuid       string
 }
 
-func (es *EventStream) start(paths []string, callbackInfo uintptr) error {
+// maxPaths is the number of paths FSEvents can watch with a single stream.
+const maxPaths = 4096
+
+// nativeHistory is true as FSEvents keeps its own history, so a Journal isn't
+// used by the native backend.
+const nativeHistory = true
+
+func newNativeBackend(es *EventStream) (backend, error) {
+	return &fsEvents{es: es}, nil
+}
+
+func (f *fsEvents) start() error {
+	es := f.es
+
+	// register eventstream in the local registry for later lookup
+	// in C callback
+	f.registryID = registry.Add(es)
+	f.uuid = GetDeviceUUID(es.Device)
 
 	since := eventIDSinceNow
 	if es.Resume {
 		since = es.EventID
 	}
 
-	es.stream = setupStream(paths, es.Flags, callbackInfo, since, es.Latency, es.Device)
+	stream, err := setupStream(es.Paths, es.Flags, f.registryID, since, es.Latency, es.Device)
+	if err != nil {
+		registry.Delete(f.registryID)
+		f.registryID = 0
+		return err
+	}
+	f.stream = stream
 
-	es.qref = fsDispatchQueueRef(C.dispatch_queue_create(nil, nil))
-	C.FSEventStreamSetDispatchQueue(es.stream, es.qref)
+	f.qref = fsDispatchQueueRef(C.dispatch_queue_create(nil, nil))
+	C.FSEventStreamSetDispatchQueue(f.stream, f.qref)
 
-	if C.FSEventStreamStart(es.stream) == 0 {
+	if C.FSEventStreamStart(f.stream) == 0 {
 		// cleanup stream
-		C.FSEventStreamInvalidate(es.stream)
-		C.FSEventStreamRelease(es.stream)
-		es.stream = nil
+		C.FSEventStreamInvalidate(f.stream)
+		C.FSEventStreamRelease(f.stream)
+		f.stream = nil
 
-		C.DispatchQueueRelease(es.qref)
-		es.qref = nil
+		C.DispatchQueueRelease(f.qref)
+		f.qref = nil
 
-		return fmt.Errorf("failed to start eventstream")
+		// Remove eventstream from the registry
+		registry.Delete(f.registryID)
+		f.registryID = 0
+
+		if len(es.Paths) > maxPaths {
+			return fmt.Errorf("%w: FSEvents can't watch more than %d paths", ErrTooManyPaths, maxPaths)
+		}
+		return errors.New("fsevents: failed to start eventstream")
 	}
 
 	if !es.hasFinalizer {
@@ -457,12 +463,60 @@ func (es *EventStream) start(paths []string, callbackInfo uintptr) error {
 	return nil
 }
 
+func (f *fsEvents) flush(sync bool) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	if f.stream != nil {
+		flush(f.stream, sync)
+	}
+}
+
+func (f *fsEvents) stop() {
+	// Callbacks blocked delivering events return, and so do the flushes
+	// waiting for them.
+	registry.Cancel(f.registryID)
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.stream != nil {
+		C.FSEventStreamStop(f.stream)
+		C.FSEventStreamInvalidate(f.stream)
+	}
+
+	// Remove eventstream from the registry, after the callbacks in
+	// progress have returned.
+	registry.Delete(f.registryID)
+	f.registryID = 0
+
+	if f.stream != nil {
+		release(f.stream, f.qref)
+		f.stream = nil
+		f.qref = nil
+	}
+}
+
+// drain does nothing, as FSEvents keeps a history: the stream that replaces
+// this one resumes from the last event delivered, which includes the events
+// that weren't.
+func (f *fsEvents) drain() {}
+
 func finalizer(es *EventStream) {
 	// If an EventStream is freed without Stop being called it will
 	// cause a panic. This avoids that, and closes the stream instead.
 	es.Stop()
 }
 
+func (f *fsEvents) describe() Description {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return Description{
+		Text:  getStreamRefDescription(f.stream),
+		Paths: getStreamRefPaths(f.stream),
+	}
+}
+
 // flush drains the event stream of undelivered events
 func flush(stream fsEventStreamRef, sync bool) {
 	if sync {
@@ -472,10 +526,8 @@ func flush(stream fsEventStreamRef, sync bool) {
 	}
 }
 
-// stop requests fsevents stops streaming events
-func stop(stream fsEventStreamRef, qref fsDispatchQueueRef) {
-	C.FSEventStreamStop(stream)
-	C.FSEventStreamInvalidate(stream)
+// release releases a stream that was stopped and invalidated
+func release(stream fsEventStreamRef, qref fsDispatchQueueRef) {
 	C.FSEventStreamRelease(stream)
 	C.DispatchQueueRelease(qref)
 }